@@ -0,0 +1,164 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Addresses are kept in hex inside transactions and blocks: either a compressed public key or, for
+// public key hash addresses, the first PublicKeyHashSize bytes of its double SHA-256. Users see them
+// Base58Check encoded behind a version byte that identifies the network and the address type, so a
+// mistyped address fails its checksum and an address of the other network is refused.
+const PublicKeyHashSize = 20
+
+type NetworkParams struct {
+	Name                 string
+	PublicKeyVersion     byte
+	PublicKeyHashVersion byte
+}
+
+var (
+	MainNet = NetworkParams{Name: "mainnet", PublicKeyVersion: 0x57, PublicKeyHashVersion: 0x58}
+	TestNet = NetworkParams{Name: "testnet", PublicKeyVersion: 0x7a, PublicKeyHashVersion: 0x7c}
+)
+
+var Networks = []*NetworkParams{&MainNet, &TestNet}
+
+var ActiveNetwork = &MainNet
+
+var (
+	legacyAddressPattern = regexp.MustCompile("^[0-9a-fA-F]{66}$")
+	publicKeyHashPattern = regexp.MustCompile("^[0-9a-f]{40}$")
+)
+
+type AddressValidation struct {
+	Valid   bool   `json:"valid"`
+	Address string `json:"address,omitempty"`
+	Encoded string `json:"encoded,omitempty"`
+	Network string `json:"network,omitempty"`
+	Legacy  bool   `json:"legacy"`
+	Message string `json:"message,omitempty"`
+}
+
+func SetNetwork(name string) error {
+	for _, network := range Networks {
+		if network.Name == name {
+			ActiveNetwork = network
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown network: %s", name)
+}
+
+// GetPublicKeyHash returns the public key hash address of a hex compressed public key.
+func GetPublicKeyHash(publicKey string) (string, error) {
+	_, err := GetPublicECDSAKeyFromCompressedAddress(publicKey)
+	if err != nil {
+		return "", err
+	}
+	publicKeyBytes, _ := hex.DecodeString(publicKey)
+	first := sha256.Sum256(publicKeyBytes)
+	second := sha256.Sum256(first[:])
+	return hex.EncodeToString(second[:PublicKeyHashSize]), nil
+}
+
+func IsPublicKeyHashAddress(address string) bool {
+	return publicKeyHashPattern.MatchString(address)
+}
+
+// IsLegacyAddress reports whether address is a compressed public key in hex, as used before
+// addresses were checksummed.
+func IsLegacyAddress(address string) bool {
+	return legacyAddressPattern.MatchString(address)
+}
+
+// EncodeAddress returns the checksummed form of a hex address on the active network.
+func EncodeAddress(address string) (string, error) {
+	version := ActiveNetwork.PublicKeyVersion
+	if IsPublicKeyHashAddress(address) {
+		version = ActiveNetwork.PublicKeyHashVersion
+	} else {
+		_, err := GetPublicECDSAKeyFromCompressedAddress(address)
+		if err != nil {
+			return "", err
+		}
+	}
+	payload, _ := hex.DecodeString(address)
+	return Base58CheckEncode(append([]byte{version}, payload...)), nil
+}
+
+// DecodeAddress accepts a checksummed address of the active network or a legacy hex public key and
+// returns the lowercase hex form used inside transactions. Public key hashes are only accepted
+// checksummed, since a mistyped hash cannot be told apart from a valid one.
+func DecodeAddress(address string) (string, error) {
+	if IsLegacyAddress(address) {
+		address = strings.ToLower(address)
+		_, err := GetPublicECDSAKeyFromCompressedAddress(address)
+		if err != nil {
+			return "", err
+		}
+		return address, nil
+	}
+	payload, err := Base58CheckDecode(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid address: %s", err.Error())
+	}
+	if len(payload) == 0 {
+		return "", errors.New("invalid address length")
+	}
+	version := payload[0]
+	decoded := hex.EncodeToString(payload[1:])
+	switch {
+	case version == ActiveNetwork.PublicKeyVersion && len(payload) == 34:
+		_, err = GetPublicECDSAKeyFromCompressedAddress(decoded)
+		if err != nil {
+			return "", err
+		}
+		return decoded, nil
+	case version == ActiveNetwork.PublicKeyHashVersion && len(payload) == PublicKeyHashSize+1:
+		return decoded, nil
+	case version == ActiveNetwork.PublicKeyVersion || version == ActiveNetwork.PublicKeyHashVersion:
+		return "", errors.New("invalid address length")
+	}
+	for _, network := range Networks {
+		if version == network.PublicKeyVersion || version == network.PublicKeyHashVersion {
+			return "", fmt.Errorf("address belongs to %s, this node runs on %s", network.Name, ActiveNetwork.Name)
+		}
+	}
+	return "", fmt.Errorf("unknown address version: %d", version)
+}
+
+// NormalizeAddress decodes public key and public key hash addresses and returns anything else, such
+// as the addresses of multisig and script outputs, unchanged.
+func NormalizeAddress(address string) string {
+	decoded, err := DecodeAddress(address)
+	if err != nil {
+		return address
+	}
+	return decoded
+}
+
+func ValidateAddress(address string) AddressValidation {
+	decoded, err := DecodeAddress(address)
+	if err != nil {
+		return AddressValidation{Valid: false, Message: err.Error()}
+	}
+	encoded, _ := EncodeAddress(decoded)
+	return AddressValidation{
+		Valid:   true,
+		Address: decoded,
+		Encoded: encoded,
+		Network: ActiveNetwork.Name,
+		Legacy:  IsLegacyAddress(address),
+	}
+}
+
+// isPublicKeyTxOut reports whether a txOut is paid to a plain address rather than locked by a
+// multisig, script or hash time lock, or carrying data.
+func isPublicKeyTxOut(txOut *TxOut) bool {
+	return !IsDataTxOut(txOut) && txOut.MultiSig == nil && txOut.LockingScript == "" && txOut.HashTimeLock == nil
+}
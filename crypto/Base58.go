@@ -0,0 +1,76 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var ErrInvalidChecksum = errors.New("invalid checksum")
+
+func Base58Encode(input []byte) string {
+	number := new(big.Int).SetBytes(input)
+	radix := big.NewInt(58)
+	modulus := new(big.Int)
+	encoded := []byte{}
+	for number.Sign() > 0 {
+		number.DivMod(number, radix, modulus)
+		encoded = append(encoded, base58Alphabet[modulus.Int64()])
+	}
+	// Leading zero bytes are kept as leading ones.
+	for i := 0; i < len(input) && input[i] == 0; i++ {
+		encoded = append(encoded, base58Alphabet[0])
+	}
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return string(encoded)
+}
+
+func Base58Decode(input string) ([]byte, error) {
+	number := new(big.Int)
+	radix := big.NewInt(58)
+	for i := range input {
+		digit := bytes.IndexByte([]byte(base58Alphabet), input[i])
+		if digit < 0 {
+			return nil, errors.New("invalid base58 character")
+		}
+		number.Mul(number, radix)
+		number.Add(number, big.NewInt(int64(digit)))
+	}
+	zeros := 0
+	for zeros < len(input) && input[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+	return append(make([]byte, zeros), number.Bytes()...), nil
+}
+
+// Base58CheckEncode appends the first four bytes of the double SHA-256 of payload before encoding.
+func Base58CheckEncode(payload []byte) string {
+	checksum := base58Checksum(payload)
+	return Base58Encode(append(append([]byte{}, payload...), checksum...))
+}
+
+func Base58CheckDecode(input string) ([]byte, error) {
+	decoded, err := Base58Decode(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) < 4 {
+		return nil, errors.New("base58check input too short")
+	}
+	payload := decoded[:len(decoded)-4]
+	if !bytes.Equal(base58Checksum(payload), decoded[len(decoded)-4:]) {
+		return nil, ErrInvalidChecksum
+	}
+	return payload, nil
+}
+
+func base58Checksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	return second[:4]
+}
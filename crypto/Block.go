@@ -3,6 +3,7 @@ package crypto
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -110,8 +111,12 @@ func CalculateHashForBlock(block *Block) string {
 	return CalculateHash(block.Index, block.PreviousHash, block.Timestamp, block.Data, block.Difficulty, block.Nonce)
 }
 
+// CalculateHash commits to the transactions through their JSON encoding. Unlike their %v formatting
+// it holds the values behind pointer fields such as multisig and hash time locks rather than their
+// memory addresses, so every node computes the same hash for a block, also after it was relayed.
 func CalculateHash(index int64, previousHash string, timestamp int64, data []Transaction, difficulty int, nonce uint32) string {
-	str := fmt.Sprintf("%d%s%d%v%d%d", index, previousHash, timestamp, data, difficulty, nonce)
+	serialized, _ := json.Marshal(data)
+	str := fmt.Sprintf("%d%s%d%s%d%d", index, previousHash, timestamp, serialized, difficulty, nonce)
 	return HashString(str)
 }
 
@@ -0,0 +1,135 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	MaxBlockSize         = 1000000
+	MaxBlockTransactions = 1000
+)
+
+// PayoutAddress receives the coinbase of blocks assembled from the pool unless the miner asks for another address.
+var PayoutAddress = ""
+
+type BlockTemplate struct {
+	Index        int64         `json:"index"`
+	PreviousHash string        `json:"previousHash"`
+	Difficulty   int           `json:"difficulty"`
+	Transactions []Transaction `json:"transactions"`
+	Fees         int64         `json:"fees"`
+	Size         int64         `json:"size"`
+}
+
+func NewCoinBaseTransaction(address string, blockIndex int64, amount int64) *Transaction {
+	transaction := NewTransaction(
+		"",
+		[]TxIn{{
+			TxOutId:    "",
+			TxOutIndex: blockIndex,
+			Signature:  "",
+		}},
+		[]TxOut{{
+			Address: address,
+			Amount:  amount,
+		}},
+	)
+	transaction.Id = GetTransactionId(transaction)
+	return transaction
+}
+
+// CreateBlockTemplate assembles the next block from the pool. Transactions are scored by their
+// ancestor fee rate, the fee rate of the transaction together with its unconfirmed ancestors not yet in
+// the block, so a high fee child pulls in the low fee parents it pays for. The best scoring package is
+// added, parents first, until the block size or transaction limit is reached. The coinbase pays the
+// reward plus all fees to payoutAddress. The template is validated as a block before it is returned.
+func CreateBlockTemplate(payoutAddress string) (*BlockTemplate, error) {
+	if payoutAddress == "" {
+		return nil, errors.New("no payout address configured")
+	}
+	payoutAddress, err := DecodeAddress(payoutAddress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payout address: %s", err.Error())
+	}
+	previousBlock := GetLatestBlock()
+	nextIndex := previousBlock.Index + 1
+	coinBase := NewCoinBaseTransaction(payoutAddress, nextIndex, CoinBaseAmount)
+	size := GetTransactionSize(coinBase)
+
+	entries := TransactionPool.GetEntries()
+	byId := make(map[string]*PoolEntry)
+	for i := range entries {
+		byId[entries[i].Transaction.Id] = &entries[i]
+	}
+	selected := []Transaction{}
+	included := make(map[string]bool)
+	skipped := make(map[string]bool)
+	var fees int64
+	for len(selected)+1 < MaxBlockTransactions {
+		var best []*PoolEntry
+		var bestFeeRate int64
+		for i := range entries {
+			id := entries[i].Transaction.Id
+			if included[id] || skipped[id] {
+				continue
+			}
+			candidate := ancestorPackage(&entries[i], byId, included)
+			_, candidateSize, candidateFees := packageTotals(candidate[0], candidate[1:])
+			feeRate := GetFeeRate(candidateFees, candidateSize)
+			if best == nil || feeRate > bestFeeRate {
+				best, bestFeeRate = candidate, feeRate
+			}
+		}
+		if best == nil {
+			break
+		}
+		_, packageSize, packageFees := packageTotals(best[0], best[1:])
+		if size+packageSize > MaxBlockSize || len(selected)+len(best)+1 > MaxBlockTransactions {
+			skipped[best[len(best)-1].Transaction.Id] = true
+			continue
+		}
+		for _, entry := range best {
+			selected = append(selected, entry.Transaction)
+			included[entry.Transaction.Id] = true
+		}
+		size += packageSize
+		fees += packageFees
+	}
+
+	coinBase = NewCoinBaseTransaction(payoutAddress, nextIndex, CoinBaseAmount+fees)
+	template := BlockTemplate{
+		Index:        nextIndex,
+		PreviousHash: previousBlock.Hash,
+		Difficulty:   GetDifficulty(GetBlockChain()),
+		Transactions: append([]Transaction{*coinBase}, selected...),
+		Fees:         fees,
+		Size:         size,
+	}
+	// The pool is not trusted blindly: a template the chain would reject is never handed to miners.
+	if !ValidateBlockTransactions(template.Transactions, GetAllUnspentTxOuts(), nextIndex) {
+		return nil, errors.New("assembled block is not valid")
+	}
+	return &template, nil
+}
+
+// ancestorPackage returns entry preceded by its pool ancestors that are not yet in the block, parents
+// before the transactions spending them.
+func ancestorPackage(entry *PoolEntry, byId map[string]*PoolEntry, included map[string]bool) []*PoolEntry {
+	result := []*PoolEntry{}
+	visited := make(map[string]bool)
+	var visit func(current *PoolEntry)
+	visit = func(current *PoolEntry) {
+		visited[current.Transaction.Id] = true
+		for i := range current.Transaction.TxIns {
+			parentId := current.Transaction.TxIns[i].TxOutId
+			parent, exists := byId[parentId]
+			if exists && !included[parentId] && !visited[parentId] {
+				visit(parent)
+			}
+		}
+		result = append(result, current)
+	}
+	visit(entry)
+	return result
+}
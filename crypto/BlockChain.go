@@ -38,6 +38,11 @@ func GetLatestBlock() *Block {
 	return BlockChain[len(BlockChain)-1]
 }
 
+// GetNextBlockHeight returns the height of the block a transaction validated now would be mined in.
+func GetNextBlockHeight() int64 {
+	return GetLatestBlock().Index + 1
+}
+
 func AddBlockToChain(block *Block) bool {
 	if isValidBlock(block, GetLatestBlock()) {
 		allUnspentTxOuts := GetAllUnspentTxOuts()
@@ -47,7 +52,16 @@ func AddBlockToChain(block *Block) bool {
 			return false
 		}
 		BlockChain = append(BlockChain, block)
+		FeeEstimates.ProcessBlock(block)
+		err = SaveFeeEstimates(FeeEstimatesFile)
+		if err != nil {
+			fmt.Printf("Fee estimates could not be saved: %s\n", err.Error())
+		}
+		WatchOnlyWallets.ProcessBlock(block)
 		UpdateTransactionPool()
+		for i := range block.Data {
+			ProcessOrphans(block.Data[i].Id)
+		}
 		BroadcastBlock(block)
 		return true
 	}
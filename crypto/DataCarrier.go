@@ -0,0 +1,90 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Data carrier outputs embed a hex encoded payload in a transaction. They carry no value and
+// can never be spent, so they are left out of the unspent txOut set.
+const (
+	MaxDataCarrierSize    = 80
+	MaxDataCarrierOutputs = 1
+)
+
+type DataCarrierMatch struct {
+	BlockIndex    int64  `json:"blockIndex"`
+	BlockHash     string `json:"blockHash"`
+	TransactionId string `json:"transactionId"`
+	TxOutIndex    int64  `json:"txOutIndex"`
+	Data          string `json:"data"`
+}
+
+func IsDataTxOut(txOut *TxOut) bool {
+	return txOut.Data != ""
+}
+
+func ValidateDataTxOut(txOut *TxOut) bool {
+	_, err := hex.DecodeString(txOut.Data)
+	if err != nil {
+		fmt.Printf("Data carrier payload is not valid hex: %s\n", err.Error())
+		return false
+	}
+	if txOut.Amount != 0 {
+		fmt.Printf("Data carrier txOut must not carry an amount\n")
+		return false
+	}
+	if txOut.Address != "" || txOut.MultiSig != nil || txOut.LockingScript != "" || txOut.HashTimeLock != nil {
+		fmt.Printf("Data carrier txOut must not be spendable\n")
+		return false
+	}
+	return true
+}
+
+// IsStandardDataCarrier applies the pool's size policy to the data outputs of a transaction.
+func IsStandardDataCarrier(transaction *Transaction) bool {
+	outputs := 0
+	for i := range transaction.TxOuts {
+		txOut := transaction.TxOuts[i]
+		if !IsDataTxOut(&txOut) {
+			continue
+		}
+		outputs++
+		if len(txOut.Data)/2 > MaxDataCarrierSize {
+			fmt.Printf("Data carrier payload exceeds %d bytes\n", MaxDataCarrierSize)
+			return false
+		}
+	}
+	if outputs > MaxDataCarrierOutputs {
+		fmt.Printf("Too many data carrier txOuts: %d\n", outputs)
+		return false
+	}
+	return true
+}
+
+// FindDataCarriers returns every data output in the chain whose payload contains the given hex string.
+func FindDataCarriers(payload string) []DataCarrierMatch {
+	payload = strings.ToLower(payload)
+	matches := []DataCarrierMatch{}
+	blockChain := GetBlockChain()
+	for i := range blockChain {
+		block := blockChain[i]
+		for j := range block.Data {
+			transaction := block.Data[j]
+			for k := range transaction.TxOuts {
+				txOut := transaction.TxOuts[k]
+				if IsDataTxOut(&txOut) && strings.Contains(strings.ToLower(txOut.Data), payload) {
+					matches = append(matches, DataCarrierMatch{
+						BlockIndex:    block.Index,
+						BlockHash:     block.Hash,
+						TransactionId: transaction.Id,
+						TxOutIndex:    int64(k),
+						Data:          txOut.Data,
+					})
+				}
+			}
+		}
+	}
+	return matches
+}
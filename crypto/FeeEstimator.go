@@ -0,0 +1,235 @@
+package crypto
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// The estimator sorts pool transactions into fee rate buckets and records how many blocks each took
+// to confirm. Older observations decay every block so the estimate follows current conditions.
+const (
+	MaxConfirmTarget      = 25
+	FeeEstimateDecay      = 0.998
+	FeeEstimateSuccess    = 0.85
+	FeeEstimateMinSamples = 20.0
+	FeeBucketSpacing      = 1.5
+	MaxFeeBucket          = 1000000
+)
+
+var FeeEstimatesFile = "fee_estimates.json"
+
+type TrackedTransaction struct {
+	Bucket int   `json:"bucket"`
+	Height int64 `json:"height"`
+}
+
+type FeeEstimator struct {
+	Buckets         []int64                       `json:"buckets"`
+	ConfirmedWithin [][]float64                   `json:"confirmedWithin"`
+	Total           []float64                     `json:"total"`
+	Tracked         map[string]TrackedTransaction `json:"tracked"`
+
+	mutex sync.Mutex
+}
+
+type FeeEstimate struct {
+	Target  int   `json:"target"`
+	FeeRate int64 `json:"feeRate"`
+}
+
+var FeeEstimates = NewFeeEstimator()
+
+func NewFeeEstimator() *FeeEstimator {
+	buckets := []int64{0}
+	for bound := 1.0; bound <= MaxFeeBucket; bound *= FeeBucketSpacing {
+		if int64(bound) > buckets[len(buckets)-1] {
+			buckets = append(buckets, int64(bound))
+		}
+	}
+	confirmedWithin := make([][]float64, MaxConfirmTarget)
+	for i := range confirmedWithin {
+		confirmedWithin[i] = make([]float64, len(buckets))
+	}
+	estimator := FeeEstimator{
+		Buckets:         buckets,
+		ConfirmedWithin: confirmedWithin,
+		Total:           make([]float64, len(buckets)),
+		Tracked:         make(map[string]TrackedTransaction),
+	}
+	return &estimator
+}
+
+// LoadFeeEstimates restores the statistics saved by a previous run, if any. A file that cannot be
+// read back leaves the node with an empty estimator.
+func LoadFeeEstimates(path string) error {
+	FeeEstimates = NewFeeEstimator()
+	out, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	estimator := NewFeeEstimator()
+	err = json.Unmarshal(out, estimator)
+	if err != nil {
+		return err
+	}
+	err = estimator.checkLayout()
+	if err != nil {
+		return err
+	}
+	if estimator.Tracked == nil {
+		estimator.Tracked = make(map[string]TrackedTransaction)
+	}
+	FeeEstimates = estimator
+	return nil
+}
+
+// checkLayout verifies that loaded statistics use the current buckets and that every row and tracked
+// transaction fits them, so later updates cannot index out of range.
+func (estimator *FeeEstimator) checkLayout() error {
+	buckets := NewFeeEstimator().Buckets
+	if len(estimator.Buckets) != len(buckets) {
+		return errors.New("fee estimates file does not match the current bucket layout")
+	}
+	for b := range buckets {
+		if estimator.Buckets[b] != buckets[b] {
+			return errors.New("fee estimates file does not match the current bucket layout")
+		}
+	}
+	if len(estimator.Total) != len(buckets) || len(estimator.ConfirmedWithin) != MaxConfirmTarget {
+		return errors.New("fee estimates file does not match the current bucket layout")
+	}
+	for t := range estimator.ConfirmedWithin {
+		if len(estimator.ConfirmedWithin[t]) != len(buckets) {
+			return fmt.Errorf("fee estimates row %d has %d buckets instead of %d", t, len(estimator.ConfirmedWithin[t]), len(buckets))
+		}
+	}
+	for id, tracked := range estimator.Tracked {
+		if tracked.Bucket < 0 || tracked.Bucket >= len(buckets) {
+			return fmt.Errorf("tracked transaction %s has an invalid bucket %d", id, tracked.Bucket)
+		}
+	}
+	return nil
+}
+
+func SaveFeeEstimates(path string) error {
+	FeeEstimates.mutex.Lock()
+	out, err := json.Marshal(FeeEstimates)
+	FeeEstimates.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	temporary := path + ".tmp"
+	err = os.WriteFile(temporary, out, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporary, path)
+}
+
+// Track starts timing a transaction that just entered the pool.
+func (estimator *FeeEstimator) Track(entry *PoolEntry) {
+	estimator.mutex.Lock()
+	defer estimator.mutex.Unlock()
+	estimator.Tracked[entry.Transaction.Id] = TrackedTransaction{
+		Bucket: estimator.bucketOf(entry.FeeRate),
+		Height: entry.Height,
+	}
+}
+
+// ProcessBlock records how long the tracked transactions in block took to confirm. Transactions still
+// pending after MaxConfirmTarget blocks count as failures in their bucket, and those that left the
+// pool unconfirmed are forgotten.
+func (estimator *FeeEstimator) ProcessBlock(block *Block) {
+	estimator.mutex.Lock()
+	defer estimator.mutex.Unlock()
+	for b := range estimator.Total {
+		estimator.Total[b] *= FeeEstimateDecay
+		for t := range estimator.ConfirmedWithin {
+			estimator.ConfirmedWithin[t][b] *= FeeEstimateDecay
+		}
+	}
+	confirmed := make(map[string]bool)
+	for i := range block.Data {
+		id := block.Data[i].Id
+		confirmed[id] = true
+		tracked, exists := estimator.Tracked[id]
+		if !exists {
+			continue
+		}
+		blocks := block.Index - tracked.Height + 1
+		if blocks < 1 {
+			blocks = 1
+		}
+		for t := blocks; t <= MaxConfirmTarget; t++ {
+			estimator.ConfirmedWithin[t-1][tracked.Bucket]++
+		}
+		estimator.Total[tracked.Bucket]++
+		delete(estimator.Tracked, id)
+	}
+	for id, tracked := range estimator.Tracked {
+		if confirmed[id] {
+			continue
+		}
+		if block.Index-tracked.Height+1 > MaxConfirmTarget {
+			estimator.Total[tracked.Bucket]++
+			delete(estimator.Tracked, id)
+		} else if TransactionPool.Get(id) == nil {
+			delete(estimator.Tracked, id)
+		}
+	}
+}
+
+// EstimateFee returns the lowest fee rate at which at least FeeEstimateSuccess of the observed
+// transactions confirmed within target blocks. Buckets are judged in groups, from the highest rate
+// down, holding at least FeeEstimateMinSamples observations, so a few lucky confirmations cannot pull
+// the estimate down; without one such group it reports insufficient data. It never returns less than
+// the pool's minimum fee rate.
+func (estimator *FeeEstimator) EstimateFee(target int) (int64, error) {
+	if target < 1 || target > MaxConfirmTarget {
+		return 0, fmt.Errorf("target must be between 1 and %d", MaxConfirmTarget)
+	}
+	estimator.mutex.Lock()
+	best := -1
+	var confirmed, total float64
+	for b := len(estimator.Buckets) - 1; b >= 0; b-- {
+		confirmed += estimator.ConfirmedWithin[target-1][b]
+		total += estimator.Total[b]
+		if total < FeeEstimateMinSamples {
+			continue
+		}
+		if confirmed/total < FeeEstimateSuccess {
+			break
+		}
+		best = b
+		confirmed, total = 0, 0
+	}
+	var feeRate int64
+	if best >= 0 {
+		feeRate = estimator.Buckets[best]
+	}
+	estimator.mutex.Unlock()
+	if best < 0 {
+		return 0, errors.New("insufficient data to estimate a fee")
+	}
+	minFeeRate := TransactionPool.GetMinFeeRate()
+	if feeRate < minFeeRate {
+		return minFeeRate, nil
+	}
+	return feeRate, nil
+}
+
+func (estimator *FeeEstimator) bucketOf(feeRate int64) int {
+	bucket := 0
+	for b := range estimator.Buckets {
+		if feeRate >= estimator.Buckets[b] {
+			bucket = b
+		}
+	}
+	return bucket
+}
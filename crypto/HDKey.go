@@ -0,0 +1,318 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Hierarchical deterministic keys follow BIP32 with the SLIP-10 rules for the NIST P-256 curve:
+// the master key is keyed with "Nist256p1 seed" and invalid child keys are retried instead of skipped.
+// Extended keys serialize as "cprv" and "cpub" rather than Bitcoin's "xprv" and "xpub", since keys on
+// another curve must not be imported by wallets that would read them as secp256k1 keys.
+const (
+	HardenedKeyStart       = 0x80000000
+	ExtendedPrivateVersion = 0x02e8da56
+	ExtendedPublicVersion  = 0x02e8de91
+	MinSeedSize            = 16
+	MaxSeedSize            = 64
+	MaxDerivedAddresses    = 1000
+)
+
+// DefaultAccountPath is the account the wallet derives its keys from. Receive addresses use chain 0
+// below it and change addresses chain 1.
+const (
+	DefaultAccountPath = "m/44'/0'/0'"
+	ReceiveChain       = 0
+	ChangeChain        = 1
+)
+
+var masterKeySalt = []byte("Nist256p1 seed")
+
+type ExtendedKey struct {
+	Key               []byte
+	ChainCode         []byte
+	Depth             byte
+	ParentFingerprint uint32
+	ChildNumber       uint32
+	Private           bool
+}
+
+type DerivedAddress struct {
+	Path    string `json:"path"`
+	Address string `json:"address"`
+	Encoded string `json:"encoded"`
+}
+
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < MinSeedSize || len(seed) > MaxSeedSize {
+		return nil, fmt.Errorf("seed must be between %d and %d bytes", MinSeedSize, MaxSeedSize)
+	}
+	sum := hmacSHA512(masterKeySalt, seed)
+	for !isValidPrivateScalar(sum[:32]) {
+		sum = hmacSHA512(masterKeySalt, sum)
+	}
+	key := ExtendedKey{
+		Key:       sum[:32],
+		ChainCode: sum[32:],
+		Private:   true,
+	}
+	return &key, nil
+}
+
+// Child derives child index of key. Indexes from HardenedKeyStart on are hardened and need a private key.
+func (key *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	hardened := index >= HardenedKeyStart
+	if hardened && !key.Private {
+		return nil, errors.New("cannot derive a hardened child from a public key")
+	}
+	if key.Depth == 255 {
+		return nil, errors.New("maximum derivation depth reached")
+	}
+	publicKey := key.PublicKeyBytes()
+	var data []byte
+	if hardened {
+		data = append([]byte{0}, key.Key...)
+	} else {
+		data = append([]byte{}, publicKey...)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	curve := elliptic.P256()
+	n := curve.Params().N
+	for {
+		sum := hmacSHA512(key.ChainCode, data)
+		tweak := new(big.Int).SetBytes(sum[:32])
+		child := ExtendedKey{
+			ChainCode:         sum[32:],
+			Depth:             key.Depth + 1,
+			ParentFingerprint: GetKeyFingerprint(publicKey),
+			ChildNumber:       index,
+			Private:           key.Private,
+		}
+		if tweak.Cmp(n) < 0 {
+			if key.Private {
+				scalar := new(big.Int).Add(tweak, new(big.Int).SetBytes(key.Key))
+				scalar.Mod(scalar, n)
+				if scalar.Sign() != 0 {
+					child.Key = scalar.FillBytes(make([]byte, 32))
+					return &child, nil
+				}
+			} else {
+				x, y := elliptic.UnmarshalCompressed(curve, key.Key)
+				tweakX, tweakY := curve.ScalarBaseMult(sum[:32])
+				childX, childY := curve.Add(x, y, tweakX, tweakY)
+				if childX.Sign() != 0 || childY.Sign() != 0 {
+					child.Key = elliptic.MarshalCompressed(curve, childX, childY)
+					return &child, nil
+				}
+			}
+		}
+		data = binary.BigEndian.AppendUint32(append([]byte{1}, sum[32:]...), index)
+	}
+}
+
+func (key *ExtendedKey) Derive(path []uint32) (*ExtendedKey, error) {
+	derived := key
+	for _, index := range path {
+		var err error
+		derived, err = derived.Child(index)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return derived, nil
+}
+
+// Neuter returns the extended public key of key.
+func (key *ExtendedKey) Neuter() *ExtendedKey {
+	if !key.Private {
+		return key
+	}
+	neutered := *key
+	neutered.Key = key.PublicKeyBytes()
+	neutered.Private = false
+	return &neutered
+}
+
+// PublicKeyBytes returns the compressed public key.
+func (key *ExtendedKey) PublicKeyBytes() []byte {
+	if !key.Private {
+		return key.Key
+	}
+	curve := elliptic.P256()
+	x, y := curve.ScalarBaseMult(key.Key)
+	return elliptic.MarshalCompressed(curve, x, y)
+}
+
+func (key *ExtendedKey) Address() string {
+	return hex.EncodeToString(key.PublicKeyBytes())
+}
+
+func (key *ExtendedKey) PrivateKey() (*ecdsa.PrivateKey, error) {
+	if !key.Private {
+		return nil, errors.New("extended key is public")
+	}
+	return GetPrivateKeyFromBytes(key.Key)
+}
+
+// String serializes key in the 78 byte BIP32 layout with a Base58Check encoding.
+func (key *ExtendedKey) String() string {
+	version := uint32(ExtendedPublicVersion)
+	keyData := key.Key
+	if key.Private {
+		version = ExtendedPrivateVersion
+		keyData = append([]byte{0}, key.Key...)
+	}
+	serialized := binary.BigEndian.AppendUint32(nil, version)
+	serialized = append(serialized, key.Depth)
+	serialized = binary.BigEndian.AppendUint32(serialized, key.ParentFingerprint)
+	serialized = binary.BigEndian.AppendUint32(serialized, key.ChildNumber)
+	serialized = append(serialized, key.ChainCode...)
+	serialized = append(serialized, keyData...)
+	return Base58CheckEncode(serialized)
+}
+
+func ParseExtendedKey(encoded string) (*ExtendedKey, error) {
+	serialized, err := Base58CheckDecode(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(serialized) != 78 {
+		return nil, errors.New("invalid extended key length")
+	}
+	key := ExtendedKey{
+		Depth:             serialized[4],
+		ParentFingerprint: binary.BigEndian.Uint32(serialized[5:9]),
+		ChildNumber:       binary.BigEndian.Uint32(serialized[9:13]),
+		ChainCode:         serialized[13:45],
+	}
+	keyData := serialized[45:]
+	switch binary.BigEndian.Uint32(serialized[:4]) {
+	case ExtendedPrivateVersion:
+		if keyData[0] != 0 || !isValidPrivateScalar(keyData[1:]) {
+			return nil, errors.New("invalid extended private key")
+		}
+		key.Key = keyData[1:]
+		key.Private = true
+	case ExtendedPublicVersion:
+		x, _ := elliptic.UnmarshalCompressed(elliptic.P256(), keyData)
+		if x == nil {
+			return nil, errors.New("invalid extended public key")
+		}
+		key.Key = keyData
+	default:
+		return nil, errors.New("unknown extended key version")
+	}
+	if key.Depth == 0 && (key.ParentFingerprint != 0 || key.ChildNumber != 0) {
+		return nil, errors.New("invalid master extended key")
+	}
+	return &key, nil
+}
+
+// ParseDerivationPath parses paths like "m/44'/0'/0'/0/5". Hardened indexes end in ' or h. A path
+// without the leading "m" is relative to the key it is applied to.
+func ParseDerivationPath(path string) ([]uint32, error) {
+	path = strings.TrimSpace(path)
+	if path == "" || path == "m" {
+		return []uint32{}, nil
+	}
+	path = strings.TrimPrefix(path, "m/")
+	indexes := []uint32{}
+	for _, component := range strings.Split(path, "/") {
+		var offset uint32
+		if strings.HasSuffix(component, "'") || strings.HasSuffix(component, "h") {
+			offset = HardenedKeyStart
+			component = component[:len(component)-1]
+		}
+		index, err := strconv.ParseUint(component, 10, 32)
+		if err != nil || index >= HardenedKeyStart {
+			return nil, fmt.Errorf("invalid path component: %s", component)
+		}
+		indexes = append(indexes, uint32(index)+offset)
+	}
+	return indexes, nil
+}
+
+// FormatDerivationPath is the inverse of ParseDerivationPath. An absolute path starts with "m".
+func FormatDerivationPath(indexes []uint32, absolute bool) string {
+	components := []string{}
+	if absolute {
+		components = append(components, "m")
+	}
+	for _, index := range indexes {
+		if index >= HardenedKeyStart {
+			components = append(components, fmt.Sprintf("%d'", index-HardenedKeyStart))
+		} else {
+			components = append(components, fmt.Sprintf("%d", index))
+		}
+	}
+	return strings.Join(components, "/")
+}
+
+// DeriveAddresses derives count consecutive addresses below path of an extended key, starting at start.
+// Paths are relative to the extended key unless it is a master key.
+func DeriveAddresses(extendedKey string, path string, start uint32, count uint32) ([]DerivedAddress, error) {
+	if count == 0 || count > MaxDerivedAddresses {
+		return nil, fmt.Errorf("count must be between 1 and %d", MaxDerivedAddresses)
+	}
+	if uint64(start)+uint64(count) > HardenedKeyStart {
+		return nil, errors.New("index range exceeds the non-hardened indexes")
+	}
+	key, err := ParseExtendedKey(extendedKey)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := ParseDerivationPath(path)
+	if err != nil {
+		return nil, err
+	}
+	parent, err := key.Derive(indexes)
+	if err != nil {
+		return nil, err
+	}
+	addresses := []DerivedAddress{}
+	for index := start; index < start+count; index++ {
+		child, err := parent.Child(index)
+		if err != nil {
+			return nil, err
+		}
+		address := child.Address()
+		encoded, _ := EncodeAddress(address)
+		addresses = append(addresses, DerivedAddress{
+			Path:    FormatDerivationPath(append(append([]uint32{}, indexes...), index), key.Depth == 0),
+			Address: address,
+			Encoded: encoded,
+		})
+	}
+	return addresses, nil
+}
+
+// GetKeyFingerprint identifies a compressed public key by the first four bytes of its double SHA-256.
+// BIP32 takes them from HASH160 instead; this chain hashes public keys with double SHA-256 throughout,
+// so the fingerprint is the start of the key's public key hash address.
+func GetKeyFingerprint(publicKey []byte) uint32 {
+	first := sha256.Sum256(publicKey)
+	second := sha256.Sum256(first[:])
+	return binary.BigEndian.Uint32(second[:4])
+}
+
+func hmacSHA512(key []byte, data []byte) []byte {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func isValidPrivateScalar(scalar []byte) bool {
+	value := new(big.Int).SetBytes(scalar)
+	return value.Sign() != 0 && value.Cmp(elliptic.P256().Params().N) < 0
+}
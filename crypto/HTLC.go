@@ -0,0 +1,190 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// HashTimeLock locks an output to the recipient, who must reveal the preimage of SecretHash,
+// or to the refund address once the chain reaches TimeoutHeight.
+type HashTimeLock struct {
+	RecipientAddress string `json:"recipientAddress"`
+	SecretHash       string `json:"secretHash"`
+	RefundAddress    string `json:"refundAddress"`
+	TimeoutHeight    int64  `json:"timeoutHeight"`
+}
+
+type RevealedPreimage struct {
+	TxOutId    string `json:"txOutId"`
+	TxOutIndex int64  `json:"txOutIndex"`
+	SecretHash string `json:"secretHash"`
+	Preimage   string `json:"preimage"`
+}
+
+// HTLCSpend is an unsigned transaction spending a hash time locked output together with the digest
+// the spender has to sign.
+type HTLCSpend struct {
+	Transaction *Transaction `json:"transaction"`
+	SigningHash string       `json:"signingHash"`
+}
+
+// GetHashTimeLockAddress returns the address a hash time locked output is listed under.
+func GetHashTimeLockAddress(hashTimeLock *HashTimeLock) string {
+	combined := fmt.Sprintf("%s%s%s%d", hashTimeLock.RecipientAddress, hashTimeLock.SecretHash, hashTimeLock.RefundAddress, hashTimeLock.TimeoutHeight)
+	return HashString(combined)
+}
+
+// ValidateHashTimeLock checks the fields of a hash time lock. The secret hash must be lowercase hex,
+// the form HashPreimage produces, or no preimage could ever match it.
+func ValidateHashTimeLock(hashTimeLock *HashTimeLock) bool {
+	secretHash, err := hex.DecodeString(hashTimeLock.SecretHash)
+	if err != nil || len(secretHash) != sha256.Size || hex.EncodeToString(secretHash) != hashTimeLock.SecretHash {
+		fmt.Printf("Invalid hash time lock secret hash: %s\n", hashTimeLock.SecretHash)
+		return false
+	}
+	_, err = GetPublicECDSAKeyFromCompressedAddress(hashTimeLock.RecipientAddress)
+	if err != nil {
+		fmt.Printf("Invalid hash time lock recipient: %s\n", err.Error())
+		return false
+	}
+	_, err = GetPublicECDSAKeyFromCompressedAddress(hashTimeLock.RefundAddress)
+	if err != nil {
+		fmt.Printf("Invalid hash time lock refund address: %s\n", err.Error())
+		return false
+	}
+	if hashTimeLock.TimeoutHeight <= 0 {
+		fmt.Printf("Invalid hash time lock timeout height: %d\n", hashTimeLock.TimeoutHeight)
+		return false
+	}
+	return true
+}
+
+func HashPreimage(preimage string) (string, error) {
+	preimageBytes, err := hex.DecodeString(preimage)
+	if err != nil {
+		return "", err
+	}
+	hashed := sha256.Sum256(preimageBytes)
+	return hex.EncodeToString(hashed[:]), nil
+}
+
+// VerifyHashTimeLock checks a spend of a hash time locked output. An input carrying a preimage takes
+// the redeem path, any other input the refund path.
+func VerifyHashTimeLock(hashTimeLock *HashTimeLock, txIn *TxIn, signingHash string, height int64) bool {
+	address := hashTimeLock.RefundAddress
+	if txIn.Preimage != "" {
+		hashed, err := HashPreimage(txIn.Preimage)
+		if err != nil {
+			fmt.Printf("Invalid preimage: %s\n", err.Error())
+			return false
+		}
+		if hashed != hashTimeLock.SecretHash {
+			fmt.Printf("Preimage does not match the secret hash\n")
+			return false
+		}
+		address = hashTimeLock.RecipientAddress
+	} else if height < hashTimeLock.TimeoutHeight {
+		fmt.Printf("Hash time lock cannot be refunded before height %d\n", hashTimeLock.TimeoutHeight)
+		return false
+	}
+	publicKey, err := GetPublicECDSAKeyFromCompressedAddress(address)
+	if err != nil {
+		fmt.Printf("Public key could not be derived from address: %s\n", err.Error())
+		return false
+	}
+	validated, err := VerifyECDSASignature(publicKey, signingHash, txIn.Signature)
+	if err != nil {
+		fmt.Printf("Signature could not be verified: %s\n", err.Error())
+		return false
+	}
+	return validated
+}
+
+func NewHashTimeLockTxOut(hashTimeLock *HashTimeLock, amount int64) TxOut {
+	return TxOut{
+		Address:      GetHashTimeLockAddress(hashTimeLock),
+		Amount:       amount,
+		HashTimeLock: hashTimeLock,
+	}
+}
+
+// BuildHashTimeLockSpend builds the unsigned transaction moving a hash time locked output to
+// toAddress. Passing a preimage builds a redeem, passing none builds a refund.
+func BuildHashTimeLockSpend(unspentTxOut *UnspentTxOut, toAddress string, fee int64, preimage string) (*HTLCSpend, error) {
+	if unspentTxOut.HashTimeLock == nil {
+		return nil, errors.New("txOut is not hash time locked")
+	}
+	if fee < 0 || fee >= unspentTxOut.Amount {
+		return nil, errors.New("invalid fee")
+	}
+	toAddress, err := DecodeAddress(toAddress)
+	if err != nil {
+		return nil, err
+	}
+	if preimage == "" && GetNextBlockHeight() < unspentTxOut.HashTimeLock.TimeoutHeight {
+		return nil, fmt.Errorf("hash time lock cannot be refunded before height %d", unspentTxOut.HashTimeLock.TimeoutHeight)
+	}
+	if preimage != "" {
+		hashed, err := HashPreimage(preimage)
+		if err != nil {
+			return nil, err
+		}
+		if hashed != unspentTxOut.HashTimeLock.SecretHash {
+			return nil, errors.New("preimage does not match the secret hash")
+		}
+	}
+	transaction := &Transaction{
+		Version: TransactionVersion2,
+		TxIns: []TxIn{{
+			TxOutId:     unspentTxOut.TxOutId,
+			TxOutIndex:  unspentTxOut.TxOutIndex,
+			Preimage:    preimage,
+			SigHashType: SigHashAll,
+		}},
+		TxOuts: []TxOut{{
+			Address: toAddress,
+			Amount:  unspentTxOut.Amount - fee,
+		}},
+	}
+	transaction.Id = GetTransactionId(transaction)
+	signingHash, err := GetSignatureHash(transaction, 0, unspentTxOut, SigHashAll)
+	if err != nil {
+		return nil, err
+	}
+	return &HTLCSpend{Transaction: transaction, SigningHash: signingHash}, nil
+}
+
+// FindRevealedPreimages returns the preimages a confirmed transaction revealed while redeeming
+// hash time locked outputs.
+func FindRevealedPreimages(transactionId string) (bool, []RevealedPreimage) {
+	blockChain := GetBlockChain()
+	for i := range blockChain {
+		for j := range blockChain[i].Data {
+			transaction := blockChain[i].Data[j]
+			if transaction.Id != transactionId {
+				continue
+			}
+			preimages := []RevealedPreimage{}
+			for k := range transaction.TxIns {
+				txIn := transaction.TxIns[k]
+				if txIn.Preimage == "" {
+					continue
+				}
+				hashed, err := HashPreimage(txIn.Preimage)
+				if err != nil {
+					continue
+				}
+				preimages = append(preimages, RevealedPreimage{
+					TxOutId:    txIn.TxOutId,
+					TxOutIndex: txIn.TxOutIndex,
+					SecretHash: hashed,
+					Preimage:   txIn.Preimage,
+				})
+			}
+			return true, preimages
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,465 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// The keystore encrypts every private key with AES-256-GCM under a key derived from the wallet
+// passphrase. The derived key is only held in memory while the wallet is unlocked.
+const (
+	KeystoreIterations   = 600000
+	KeystoreSaltSize     = 16
+	KeystoreSeedSize     = 32
+	DefaultUnlockTimeout = 5 * 60
+)
+
+// keystoreCheck is encrypted with the derived key so a wrong passphrase is detected even when the
+// keystore holds no keys.
+const keystoreCheck = "keystore"
+
+var KeystoreFile = "keystore.json"
+
+var (
+	ErrNoKeystore   = errors.New("no keystore has been created")
+	ErrWalletLocked = errors.New("wallet is locked")
+	ErrUnknownKey   = errors.New("address is not in the keystore")
+)
+
+type EncryptedKey struct {
+	Address    string `json:"address"`
+	Path       string `json:"path,omitempty"`
+	Nonce      string `json:"nonce"`
+	CipherText string `json:"cipherText"`
+	Created    int64  `json:"created"`
+}
+
+type EncryptedSeed struct {
+	Nonce      string `json:"nonce"`
+	CipherText string `json:"cipherText"`
+}
+
+type Keystore struct {
+	Salt       string         `json:"salt"`
+	Iterations int            `json:"iterations"`
+	CheckNonce string         `json:"checkNonce"`
+	Check      string         `json:"check"`
+	Keys       []EncryptedKey `json:"keys"`
+	// Seed is the root of the keys derived below DefaultAccountPath. AccountPublicKey is the extended
+	// public key of that account, kept in the clear so addresses can be listed while the wallet is locked.
+	Seed             *EncryptedSeed `json:"seed,omitempty"`
+	AccountPublicKey string         `json:"accountPublicKey,omitempty"`
+	ReceiveIndex     uint32         `json:"receiveIndex"`
+	ChangeIndex      uint32         `json:"changeIndex"`
+
+	mutex         sync.Mutex
+	encryptionKey []byte
+	unlockedUntil int64
+	lockTimer     *time.Timer
+}
+
+type KeystoreStatus struct {
+	Created          bool     `json:"created"`
+	Locked           bool     `json:"locked"`
+	UnlockedUntil    int64    `json:"unlockedUntil,omitempty"`
+	AccountPublicKey string   `json:"accountPublicKey,omitempty"`
+	Addresses        []string `json:"addresses"`
+}
+
+var WalletKeystore = &Keystore{}
+
+// LoadKeystore reads the keystore file, if any. The wallet starts locked.
+func LoadKeystore(path string) error {
+	out, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	keystore := &Keystore{}
+	err = json.Unmarshal(out, keystore)
+	if err != nil {
+		return err
+	}
+	WalletKeystore = keystore
+	return nil
+}
+
+// Create initialises a keystore with a fresh seed, protected by passphrase, and leaves it unlocked for
+// timeout seconds.
+func (keystore *Keystore) Create(passphrase string, timeout int64) error {
+	if passphrase == "" {
+		return errors.New("passphrase must not be empty")
+	}
+	keystore.mutex.Lock()
+	defer keystore.mutex.Unlock()
+	if keystore.created() {
+		return errors.New("keystore already exists")
+	}
+	salt := make([]byte, KeystoreSaltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return err
+	}
+	encryptionKey, err := pbkdf2.Key(sha256.New, passphrase, salt, KeystoreIterations, 32)
+	if err != nil {
+		return err
+	}
+	nonce, cipherText, err := encryptWithKey(encryptionKey, []byte(keystoreCheck), nil)
+	if err != nil {
+		return err
+	}
+	keystore.Salt = hex.EncodeToString(salt)
+	keystore.Iterations = KeystoreIterations
+	keystore.CheckNonce = nonce
+	keystore.Check = cipherText
+	keystore.Keys = []EncryptedKey{}
+	keystore.unlock(encryptionKey, timeout)
+	err = keystore.generateSeed()
+	if err != nil {
+		keystore.lock()
+		keystore.Salt = ""
+		return err
+	}
+	return nil
+}
+
+// Unlock derives the encryption key from passphrase and keeps it for timeout seconds.
+func (keystore *Keystore) Unlock(passphrase string, timeout int64) error {
+	keystore.mutex.Lock()
+	defer keystore.mutex.Unlock()
+	if !keystore.created() {
+		return ErrNoKeystore
+	}
+	salt, err := hex.DecodeString(keystore.Salt)
+	if err != nil {
+		return err
+	}
+	encryptionKey, err := pbkdf2.Key(sha256.New, passphrase, salt, keystore.Iterations, 32)
+	if err != nil {
+		return err
+	}
+	check, err := decryptWithKey(encryptionKey, keystore.CheckNonce, keystore.Check, nil)
+	if err != nil || string(check) != keystoreCheck {
+		return errors.New("incorrect passphrase")
+	}
+	keystore.unlock(encryptionKey, timeout)
+	return nil
+}
+
+func (keystore *Keystore) Lock() {
+	keystore.mutex.Lock()
+	defer keystore.mutex.Unlock()
+	keystore.lock()
+}
+
+// NewKey derives the next receive key, stores it encrypted and returns its address.
+func (keystore *Keystore) NewKey() (string, error) {
+	return keystore.deriveNextKey(ReceiveChain)
+}
+
+// NewChangeKey derives the next key on the change chain.
+func (keystore *Keystore) NewChangeKey() (string, error) {
+	return keystore.deriveNextKey(ChangeChain)
+}
+
+// SetSeed replaces the seed of a keystore that holds no derived keys yet.
+func (keystore *Keystore) SetSeed(seed []byte) error {
+	keystore.mutex.Lock()
+	defer keystore.mutex.Unlock()
+	if !keystore.unlocked() {
+		return ErrWalletLocked
+	}
+	for i := range keystore.Keys {
+		if keystore.Keys[i].Path != "" {
+			return errors.New("keystore already holds derived keys")
+		}
+	}
+	return keystore.setSeed(seed)
+}
+
+// AddKey stores an existing P-256 private key and returns its address.
+func (keystore *Keystore) AddKey(privateKey *ecdsa.PrivateKey) (string, error) {
+	if privateKey.Curve != elliptic.P256() {
+		return "", errors.New("only P-256 keys are supported")
+	}
+	keystore.mutex.Lock()
+	defer keystore.mutex.Unlock()
+	if !keystore.unlocked() {
+		return "", ErrWalletLocked
+	}
+	return keystore.addKey(privateKey, "")
+}
+
+func (keystore *Keystore) addKey(privateKey *ecdsa.PrivateKey, path string) (string, error) {
+	address := GetCompressedAddress(&privateKey.PublicKey)
+	if keystore.find(address) != nil {
+		return address, nil
+	}
+	nonce, cipherText, err := encryptWithKey(keystore.encryptionKey, GetPrivateKeyBytes(privateKey), []byte(address))
+	if err != nil {
+		return "", err
+	}
+	keystore.Keys = append(keystore.Keys, EncryptedKey{
+		Address:    address,
+		Path:       path,
+		Nonce:      nonce,
+		CipherText: cipherText,
+		Created:    CurrentUnixTimestamp(),
+	})
+	err = keystore.save()
+	if err != nil {
+		keystore.Keys = keystore.Keys[:len(keystore.Keys)-1]
+		return "", err
+	}
+	return address, nil
+}
+
+// GetPrivateKey decrypts the key of address. The wallet must be unlocked.
+func (keystore *Keystore) GetPrivateKey(address string) (*ecdsa.PrivateKey, error) {
+	keystore.mutex.Lock()
+	defer keystore.mutex.Unlock()
+	if !keystore.unlocked() {
+		return nil, ErrWalletLocked
+	}
+	encryptedKey := keystore.find(address)
+	if encryptedKey == nil {
+		return nil, ErrUnknownKey
+	}
+	privateBytes, err := decryptWithKey(keystore.encryptionKey, encryptedKey.Nonce, encryptedKey.CipherText, []byte(encryptedKey.Address))
+	if err != nil {
+		return nil, err
+	}
+	return GetPrivateKeyFromBytes(privateBytes)
+}
+
+func (keystore *Keystore) HasKey(address string) bool {
+	keystore.mutex.Lock()
+	defer keystore.mutex.Unlock()
+	return keystore.find(address) != nil
+}
+
+func (keystore *Keystore) GetStatus() KeystoreStatus {
+	keystore.mutex.Lock()
+	defer keystore.mutex.Unlock()
+	status := KeystoreStatus{
+		Created:   keystore.created(),
+		Locked:    !keystore.unlocked(),
+		Addresses: []string{},
+
+		AccountPublicKey: keystore.AccountPublicKey,
+	}
+	if !status.Locked {
+		status.UnlockedUntil = keystore.unlockedUntil
+	}
+	for i := range keystore.Keys {
+		status.Addresses = append(status.Addresses, keystore.Keys[i].Address)
+	}
+	return status
+}
+
+func (keystore *Keystore) deriveNextKey(chain uint32) (string, error) {
+	keystore.mutex.Lock()
+	defer keystore.mutex.Unlock()
+	if !keystore.unlocked() {
+		return "", ErrWalletLocked
+	}
+	// Keystores created before keys were derived get their seed on first use.
+	if keystore.Seed == nil {
+		err := keystore.generateSeed()
+		if err != nil {
+			return "", err
+		}
+	}
+	seed, err := decryptWithKey(keystore.encryptionKey, keystore.Seed.Nonce, keystore.Seed.CipherText, []byte("seed"))
+	if err != nil {
+		return "", err
+	}
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		return "", err
+	}
+	index := &keystore.ReceiveIndex
+	if chain == ChangeChain {
+		index = &keystore.ChangeIndex
+	}
+	accountPath, _ := ParseDerivationPath(DefaultAccountPath)
+	path := append(accountPath, chain, *index)
+	derived, err := master.Derive(path)
+	if err != nil {
+		return "", err
+	}
+	privateKey, err := derived.PrivateKey()
+	if err != nil {
+		return "", err
+	}
+	*index++
+	address, err := keystore.addKey(privateKey, FormatDerivationPath(path, true))
+	if err != nil {
+		*index--
+		return "", err
+	}
+	return address, nil
+}
+
+func (keystore *Keystore) generateSeed() error {
+	seed := make([]byte, KeystoreSeedSize)
+	_, err := rand.Read(seed)
+	if err != nil {
+		return err
+	}
+	return keystore.setSeed(seed)
+}
+
+func (keystore *Keystore) setSeed(seed []byte) error {
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		return err
+	}
+	accountPath, _ := ParseDerivationPath(DefaultAccountPath)
+	account, err := master.Derive(accountPath)
+	if err != nil {
+		return err
+	}
+	nonce, cipherText, err := encryptWithKey(keystore.encryptionKey, seed, []byte("seed"))
+	if err != nil {
+		return err
+	}
+	previousSeed, previousAccount := keystore.Seed, keystore.AccountPublicKey
+	previousReceive, previousChange := keystore.ReceiveIndex, keystore.ChangeIndex
+	keystore.Seed = &EncryptedSeed{Nonce: nonce, CipherText: cipherText}
+	keystore.AccountPublicKey = account.Neuter().String()
+	keystore.ReceiveIndex = 0
+	keystore.ChangeIndex = 0
+	err = keystore.save()
+	if err != nil {
+		keystore.Seed, keystore.AccountPublicKey = previousSeed, previousAccount
+		keystore.ReceiveIndex, keystore.ChangeIndex = previousReceive, previousChange
+		return err
+	}
+	return nil
+}
+
+func (keystore *Keystore) created() bool {
+	return keystore.Salt != ""
+}
+
+func (keystore *Keystore) unlocked() bool {
+	return keystore.encryptionKey != nil && CurrentUnixTimestamp() < keystore.unlockedUntil
+}
+
+func (keystore *Keystore) unlock(encryptionKey []byte, timeout int64) {
+	if timeout <= 0 {
+		timeout = DefaultUnlockTimeout
+	}
+	if keystore.lockTimer != nil {
+		keystore.lockTimer.Stop()
+	}
+	keystore.encryptionKey = encryptionKey
+	keystore.unlockedUntil = CurrentUnixTimestamp() + timeout
+	keystore.lockTimer = time.AfterFunc(time.Duration(timeout)*time.Second, keystore.lockIfExpired)
+}
+
+func (keystore *Keystore) lockIfExpired() {
+	keystore.mutex.Lock()
+	defer keystore.mutex.Unlock()
+	if CurrentUnixTimestamp() >= keystore.unlockedUntil {
+		keystore.lock()
+	}
+}
+
+func (keystore *Keystore) lock() {
+	if keystore.lockTimer != nil {
+		keystore.lockTimer.Stop()
+		keystore.lockTimer = nil
+	}
+	for i := range keystore.encryptionKey {
+		keystore.encryptionKey[i] = 0
+	}
+	keystore.encryptionKey = nil
+	keystore.unlockedUntil = 0
+}
+
+// find returns the key of a public key address or of a public key hash address.
+func (keystore *Keystore) find(address string) *EncryptedKey {
+	hashed := IsPublicKeyHashAddress(address)
+	for i := range keystore.Keys {
+		if keystore.Keys[i].Address == address {
+			return &keystore.Keys[i]
+		}
+		if hashed {
+			publicKeyHash, _ := GetPublicKeyHash(keystore.Keys[i].Address)
+			if publicKeyHash == address {
+				return &keystore.Keys[i]
+			}
+		}
+	}
+	return nil
+}
+
+// save writes the keystore next to its destination first so a crash cannot leave a truncated file.
+func (keystore *Keystore) save() error {
+	out, err := json.MarshalIndent(keystore, "", "  ")
+	if err != nil {
+		return err
+	}
+	temporary := KeystoreFile + ".tmp"
+	err = os.WriteFile(temporary, out, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporary, KeystoreFile)
+}
+
+func encryptWithKey(encryptionKey []byte, plainText []byte, additionalData []byte) (string, string, error) {
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return "", "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", "", err
+	}
+	cipherText := gcm.Seal(nil, nonce, plainText, additionalData)
+	return hex.EncodeToString(nonce), hex.EncodeToString(cipherText), nil
+}
+
+func decryptWithKey(encryptionKey []byte, nonceHex string, cipherTextHex string, additionalData []byte) ([]byte, error) {
+	nonce, err := hex.DecodeString(nonceHex)
+	if err != nil {
+		return nil, err
+	}
+	cipherText, err := hex.DecodeString(cipherTextHex)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+	return gcm.Open(nil, nonce, cipherText, additionalData)
+}
@@ -0,0 +1,227 @@
+package crypto
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Mnemonics follow BIP39: the entropy and a checksum of its SHA-256 are split into 11 bit words,
+// and the seed is PBKDF2-HMAC-SHA512 of the sentence salted with "mnemonic" and an optional
+// passphrase. A different passphrase restores a different, equally valid wallet.
+const (
+	MnemonicIterations   = 2048
+	MnemonicSeedSize     = 64
+	DefaultMnemonicWords = 24
+)
+
+var wordIndexes map[string]int
+
+func init() {
+	wordIndexes = make(map[string]int, len(mnemonicWords))
+	for i, word := range mnemonicWords {
+		wordIndexes[word] = i
+	}
+}
+
+type MnemonicParams struct {
+	Words      int    `json:"words"`
+	Passphrase string `json:"passphrase"`
+}
+
+type RestoreParams struct {
+	Mnemonic   string `json:"mnemonic"`
+	Passphrase string `json:"passphrase"`
+	GapLimit   uint32 `json:"gapLimit"`
+}
+
+type MnemonicResponse struct {
+	Mnemonic         string `json:"mnemonic"`
+	AccountPublicKey string `json:"accountPublicKey"`
+}
+
+type RestoreResult struct {
+	AccountPublicKey string   `json:"accountPublicKey"`
+	ReceiveIndex     uint32   `json:"receiveIndex"`
+	ChangeIndex      uint32   `json:"changeIndex"`
+	Addresses        []string `json:"addresses"`
+	Balance          int64    `json:"balance"`
+}
+
+// GenerateMnemonic returns a sentence of words random words: 12, 15, 18, 21 or 24.
+func GenerateMnemonic(words int) (string, error) {
+	if words%3 != 0 || words < 12 || words > 24 {
+		return "", fmt.Errorf("mnemonic must have 12, 15, 18, 21 or 24 words, not %d", words)
+	}
+	entropy := make([]byte, words*4/3)
+	_, err := rand.Read(entropy)
+	if err != nil {
+		return "", err
+	}
+	return NewMnemonic(entropy)
+}
+
+// NewMnemonic encodes 16 to 32 bytes of entropy, in steps of four, as a mnemonic sentence.
+func NewMnemonic(entropy []byte) (string, error) {
+	if len(entropy)%4 != 0 || len(entropy) < 16 || len(entropy) > 32 {
+		return "", errors.New("entropy must be 16 to 32 bytes in steps of four")
+	}
+	checksumBits := len(entropy) / 4
+	checksum := sha256.Sum256(entropy)
+	value := new(big.Int).SetBytes(entropy)
+	value.Lsh(value, uint(checksumBits))
+	value.Or(value, big.NewInt(int64(checksum[0]>>(8-checksumBits))))
+
+	count := (len(entropy)*8 + checksumBits) / 11
+	words := make([]string, count)
+	mask := big.NewInt(2047)
+	for i := count - 1; i >= 0; i-- {
+		words[i] = mnemonicWords[new(big.Int).And(value, mask).Int64()]
+		value.Rsh(value, 11)
+	}
+	return strings.Join(words, " "), nil
+}
+
+// ParseMnemonic checks the words and the checksum of a mnemonic and returns its entropy.
+func ParseMnemonic(mnemonic string) ([]byte, error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+	if len(words)%3 != 0 || len(words) < 12 || len(words) > 24 {
+		return nil, fmt.Errorf("mnemonic must have 12, 15, 18, 21 or 24 words, not %d", len(words))
+	}
+	value := new(big.Int)
+	for _, word := range words {
+		index, exists := wordIndexes[word]
+		if !exists {
+			return nil, fmt.Errorf("unknown mnemonic word: %s", word)
+		}
+		value.Lsh(value, 11)
+		value.Or(value, big.NewInt(int64(index)))
+	}
+	checksumBits := len(words) / 3
+	checksum := new(big.Int).And(value, big.NewInt(int64(1)<<checksumBits-1)).Int64()
+	value.Rsh(value, uint(checksumBits))
+	entropy := value.FillBytes(make([]byte, checksumBits*4))
+	expected := sha256.Sum256(entropy)
+	if int64(expected[0]>>(8-checksumBits)) != checksum {
+		return nil, errors.New("invalid mnemonic checksum")
+	}
+	return entropy, nil
+}
+
+// MnemonicToSeed derives the wallet seed of a mnemonic. The words are joined by single spaces.
+func MnemonicToSeed(mnemonic string, passphrase string) ([]byte, error) {
+	_, err := ParseMnemonic(mnemonic)
+	if err != nil {
+		return nil, err
+	}
+	sentence := strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+	return pbkdf2.Key(sha512.New, sentence, []byte("mnemonic"+passphrase), MnemonicIterations, MnemonicSeedSize)
+}
+
+// NewMnemonic replaces the seed of the keystore by one from a new mnemonic, which the user has to
+// write down. It fails once keys have been derived from the current seed.
+func (keystore *Keystore) NewMnemonic(words int, passphrase string) (*MnemonicResponse, error) {
+	if words == 0 {
+		words = DefaultMnemonicWords
+	}
+	mnemonic, err := GenerateMnemonic(words)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := MnemonicToSeed(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	err = keystore.SetSeed(seed)
+	if err != nil {
+		return nil, err
+	}
+	response := MnemonicResponse{Mnemonic: mnemonic, AccountPublicKey: keystore.GetStatus().AccountPublicKey}
+	return &response, nil
+}
+
+// Restore sets the seed of a mnemonic and derives the keys the chain shows were used. Each chain is
+// scanned until gapLimit consecutive addresses never received coins.
+func (keystore *Keystore) Restore(mnemonic string, passphrase string, gapLimit uint32) (*RestoreResult, error) {
+	if gapLimit == 0 {
+		gapLimit = DefaultGapLimit
+	}
+	if gapLimit > MaxDerivedAddresses {
+		return nil, fmt.Errorf("gap limit must not exceed %d", MaxDerivedAddresses)
+	}
+	seed, err := MnemonicToSeed(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	err = keystore.SetSeed(seed)
+	if err != nil {
+		return nil, err
+	}
+	accountPublicKey := keystore.GetStatus().AccountPublicKey
+	used := getUsedAddresses()
+	result := RestoreResult{AccountPublicKey: accountPublicKey, Addresses: []string{}}
+	for _, chain := range []uint32{ReceiveChain, ChangeChain} {
+		count, err := scanUsedKeys(accountPublicKey, chain, gapLimit, used)
+		if err != nil {
+			return nil, err
+		}
+		for i := uint32(0); i < count; i++ {
+			address, err := keystore.deriveNextKey(chain)
+			if err != nil {
+				return nil, err
+			}
+			result.Addresses = append(result.Addresses, address)
+			result.Balance += GetBalanceOfUnspentTxOuts(GetUnspentTxOutsOfAddress(address))
+			publicKeyHash, _ := GetPublicKeyHash(address)
+			result.Balance += GetBalanceOfUnspentTxOuts(GetUnspentTxOutsOfAddress(publicKeyHash))
+		}
+		if chain == ChangeChain {
+			result.ChangeIndex = count
+		} else {
+			result.ReceiveIndex = count
+		}
+	}
+	_, err = WatchOnlyWallets.Rescan("", 0)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// scanUsedKeys returns the number of keys on chain up to the last one whose address, or public key
+// hash address, appears in used.
+func scanUsedKeys(accountPublicKey string, chain uint32, gapLimit uint32, used map[string]bool) (uint32, error) {
+	var count uint32
+	for start := uint32(0); start < count+gapLimit; start += gapLimit {
+		addresses, err := DeriveAddresses(accountPublicKey, fmt.Sprintf("%d", chain), start, gapLimit)
+		if err != nil {
+			return 0, err
+		}
+		for i, address := range addresses {
+			publicKeyHash, _ := GetPublicKeyHash(address.Address)
+			if used[address.Address] || used[publicKeyHash] {
+				count = start + uint32(i) + 1
+			}
+		}
+	}
+	return count, nil
+}
+
+// getUsedAddresses returns every address the chain has paid to.
+func getUsedAddresses() map[string]bool {
+	used := make(map[string]bool)
+	blockChain := GetBlockChain()
+	for i := range blockChain {
+		for j := range blockChain[i].Data {
+			for _, txOut := range blockChain[i].Data[j].TxOuts {
+				used[txOut.Address] = true
+			}
+		}
+	}
+	return used
+}
@@ -0,0 +1,84 @@
+package crypto
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	MaxMultiSigKeys = 16
+)
+
+// MultiSig locks an output to Required signatures out of the listed compressed public keys.
+type MultiSig struct {
+	Required   int      `json:"required"`
+	PublicKeys []string `json:"publicKeys"`
+}
+
+func NewMultiSig(required int, publicKeys []string) *MultiSig {
+	multiSig := MultiSig{
+		Required:   required,
+		PublicKeys: publicKeys,
+	}
+	return &multiSig
+}
+
+// GetMultiSigAddress returns the address a multisig output is listed under, committing to the threshold and keys.
+func GetMultiSigAddress(multiSig *MultiSig) string {
+	combined := fmt.Sprintf("%d%s", multiSig.Required, strings.Join(multiSig.PublicKeys, ""))
+	return HashString(combined)
+}
+
+func ValidateMultiSig(multiSig *MultiSig) bool {
+	if len(multiSig.PublicKeys) == 0 || len(multiSig.PublicKeys) > MaxMultiSigKeys {
+		fmt.Printf("Invalid number of multisig public keys: %d\n", len(multiSig.PublicKeys))
+		return false
+	}
+	if multiSig.Required < 1 || multiSig.Required > len(multiSig.PublicKeys) {
+		fmt.Printf("Invalid multisig threshold: %d of %d\n", multiSig.Required, len(multiSig.PublicKeys))
+		return false
+	}
+	for i := range multiSig.PublicKeys {
+		_, err := GetPublicECDSAKeyFromCompressedAddress(multiSig.PublicKeys[i])
+		if err != nil {
+			fmt.Printf("Invalid multisig public key %s: %s\n", multiSig.PublicKeys[i], err.Error())
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyMultiSig checks that the signatures satisfy the multisig threshold.
+// Signatures must be given in the same order as the public keys they belong to.
+func VerifyMultiSig(multiSig *MultiSig, hashed string, signatures []string) bool {
+	if len(signatures) != multiSig.Required {
+		fmt.Printf("Expected %d signatures, got %d\n", multiSig.Required, len(signatures))
+		return false
+	}
+	keyIndex := 0
+	for i := range signatures {
+		matched := false
+		for keyIndex < len(multiSig.PublicKeys) {
+			publicKey, err := GetPublicECDSAKeyFromCompressedAddress(multiSig.PublicKeys[keyIndex])
+			keyIndex++
+			if err != nil {
+				fmt.Printf("Public key could not be derived from address: %s\n", err.Error())
+				return false
+			}
+			validated, err := VerifyECDSASignature(publicKey, hashed, signatures[i])
+			if err != nil {
+				fmt.Printf("Signature could not be verified: %s\n", err.Error())
+				return false
+			}
+			if validated {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fmt.Printf("Signature %d does not match any remaining public key\n", i)
+			return false
+		}
+	}
+	return true
+}
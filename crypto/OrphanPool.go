@@ -0,0 +1,188 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+)
+
+// Orphans are transactions spending outputs of transactions this node has not seen yet. They are
+// kept until a parent arrives, bounded in number, size and age so they cannot exhaust memory.
+const (
+	MaxOrphanTransactions    = 100
+	MaxOrphanTransactionSize = 100000
+	OrphanExpiry             = 20 * 60
+)
+
+var ErrOrphanTransaction = errors.New("transaction spends unknown outputs and was kept as an orphan")
+
+type OrphanEntry struct {
+	Transaction Transaction `json:"transaction"`
+	Missing     []TxIn      `json:"missing"`
+	Time        int64       `json:"time"`
+}
+
+type OrphanPool struct {
+	MaxTransactions int
+
+	mutex      sync.Mutex
+	entries    map[string]*OrphanEntry
+	byOutpoint map[string]map[string]bool
+}
+
+var Orphans = NewOrphanPool(MaxOrphanTransactions)
+
+func NewOrphanPool(maxTransactions int) *OrphanPool {
+	pool := OrphanPool{
+		MaxTransactions: maxTransactions,
+		entries:         make(map[string]*OrphanEntry),
+		byOutpoint:      make(map[string]map[string]bool),
+	}
+	return &pool
+}
+
+func GetOutpoint(txOutId string, txOutIndex int64) string {
+	return fmt.Sprintf("%s:%d", txOutId, txOutIndex)
+}
+
+// GetMissingTxIns returns the inputs of transaction whose referenced txOut is not in unspentTxOuts.
+func GetMissingTxIns(transaction *Transaction, unspentTxOuts []UnspentTxOut) []TxIn {
+	missing := []TxIn{}
+	for i := range transaction.TxIns {
+		if FindReferencedTxOut(&transaction.TxIns[i], unspentTxOuts) == nil {
+			missing = append(missing, transaction.TxIns[i])
+		}
+	}
+	return missing
+}
+
+// FindTransaction looks a transaction up in the pool and in the chain.
+func FindTransaction(id string) (bool, Transaction) {
+	entry := TransactionPool.Get(id)
+	if entry != nil {
+		return true, entry.Transaction
+	}
+	blockChain := GetBlockChain()
+	for i := range blockChain {
+		for j := range blockChain[i].Data {
+			if blockChain[i].Data[j].Id == id {
+				return true, blockChain[i].Data[j]
+			}
+		}
+	}
+	return false, Transaction{}
+}
+
+// ProcessOrphans retries the orphans waiting for parentId, and in turn the orphans of every
+// transaction accepted that way.
+func ProcessOrphans(parentId string) {
+	queue := []string{parentId}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, orphan := range Orphans.TakeChildren(id) {
+			err := acceptToTransactionPool(orphan)
+			if err == nil {
+				fmt.Printf("Orphan transaction %s accepted to the pool\n", orphan.Id)
+				queue = append(queue, orphan.Id)
+			} else if err != ErrOrphanTransaction {
+				fmt.Printf("Orphan transaction %s rejected: %s\n", orphan.Id, err.Error())
+			}
+		}
+	}
+}
+
+func (pool *OrphanPool) Add(transaction Transaction, missing []TxIn) error {
+	if GetTransactionSize(&transaction) > MaxOrphanTransactionSize {
+		return errors.New("orphan transaction is too large")
+	}
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.remove(transaction.Id)
+	pool.expire()
+	for len(pool.entries) >= pool.MaxTransactions {
+		pool.evictRandom()
+	}
+	pool.entries[transaction.Id] = &OrphanEntry{
+		Transaction: transaction,
+		Missing:     missing,
+		Time:        CurrentUnixTimestamp(),
+	}
+	for i := range missing {
+		outpoint := GetOutpoint(missing[i].TxOutId, missing[i].TxOutIndex)
+		if pool.byOutpoint[outpoint] == nil {
+			pool.byOutpoint[outpoint] = make(map[string]bool)
+		}
+		pool.byOutpoint[outpoint][transaction.Id] = true
+	}
+	return nil
+}
+
+// TakeChildren removes and returns the orphans waiting for an output of parentId.
+func (pool *OrphanPool) TakeChildren(parentId string) []Transaction {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	prefix := parentId + ":"
+	childIds := make(map[string]bool)
+	for outpoint, ids := range pool.byOutpoint {
+		if strings.HasPrefix(outpoint, prefix) {
+			for id := range ids {
+				childIds[id] = true
+			}
+		}
+	}
+	children := []Transaction{}
+	for id := range childIds {
+		children = append(children, pool.entries[id].Transaction)
+		pool.remove(id)
+	}
+	return children
+}
+
+func (pool *OrphanPool) GetEntries() []OrphanEntry {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	entries := make([]OrphanEntry, 0, len(pool.entries))
+	for _, entry := range pool.entries {
+		entries = append(entries, *entry)
+	}
+	return entries
+}
+
+func (pool *OrphanPool) remove(id string) {
+	entry, exists := pool.entries[id]
+	if !exists {
+		return
+	}
+	for i := range entry.Missing {
+		outpoint := GetOutpoint(entry.Missing[i].TxOutId, entry.Missing[i].TxOutIndex)
+		delete(pool.byOutpoint[outpoint], id)
+		if len(pool.byOutpoint[outpoint]) == 0 {
+			delete(pool.byOutpoint, outpoint)
+		}
+	}
+	delete(pool.entries, id)
+}
+
+func (pool *OrphanPool) expire() {
+	cutoff := CurrentUnixTimestamp() - OrphanExpiry
+	for id, entry := range pool.entries {
+		if entry.Time < cutoff {
+			pool.remove(id)
+		}
+	}
+}
+
+func (pool *OrphanPool) evictRandom() {
+	victim := rand.Intn(len(pool.entries))
+	for id := range pool.entries {
+		if victim == 0 {
+			fmt.Printf("Evicted orphan transaction %s\n", id)
+			pool.remove(id)
+			return
+		}
+		victim--
+	}
+}
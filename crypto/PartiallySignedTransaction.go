@@ -0,0 +1,375 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// A partially signed transaction travels between signers that each hold some of the keys, such as the
+// members of a multisig or an offline signer. It carries the unsigned transaction with the txOuts it
+// spends, so a signer can check amounts and compute signature hashes without access to the chain, and
+// the signatures collected so far. It is exchanged as Base64 encoded JSON.
+const PartiallySignedVersion = 1
+
+type PartialSignature struct {
+	PublicKey string `json:"publicKey"`
+	Signature string `json:"signature"`
+}
+
+type PartiallySignedInput struct {
+	UnspentTxOut UnspentTxOut       `json:"unspentTxOut"`
+	Signatures   []PartialSignature `json:"signatures"`
+}
+
+type PartiallySignedTransaction struct {
+	Version     int                    `json:"version"`
+	Transaction Transaction            `json:"transaction"`
+	Inputs      []PartiallySignedInput `json:"inputs"`
+}
+
+// PartiallySignedStatus reports the signatures still missing before a partially signed transaction
+// can be finalized.
+type PartiallySignedStatus struct {
+	PartiallySigned string `json:"psbt"`
+	TransactionId   string `json:"txid"`
+	Fee             int64  `json:"fee"`
+	Complete        bool   `json:"complete"`
+	Missing         []int  `json:"missing"`
+}
+
+type PartiallySignedParams struct {
+	PartiallySigned string `json:"psbt"`
+}
+
+type CombineParams struct {
+	PartiallySigned []string `json:"psbts"`
+}
+
+// CreatePartiallySignedTransaction strips the signatures of transaction and attaches the txOuts its
+// inputs spend, from the chain or the pool. Inputs without a signature hash type commit to all inputs
+// and outputs.
+func CreatePartiallySignedTransaction(transaction Transaction) (*PartiallySignedTransaction, error) {
+	if len(transaction.TxIns) == 0 {
+		return nil, errors.New("transaction has no inputs")
+	}
+	if transaction.Version == 0 {
+		transaction.Version = TransactionVersion2
+	}
+	unspentTxOuts := GetUnspentTxOutsWithPool()
+	unsigned := Transaction{
+		Version: transaction.Version,
+		TxIns:   make([]TxIn, len(transaction.TxIns)),
+		TxOuts:  append([]TxOut{}, transaction.TxOuts...),
+	}
+	partiallySigned := PartiallySignedTransaction{
+		Version: PartiallySignedVersion,
+		Inputs:  make([]PartiallySignedInput, len(transaction.TxIns)),
+	}
+	for i, txIn := range transaction.TxIns {
+		sigHashType := txIn.SigHashType
+		if sigHashType == SigHashLegacy {
+			sigHashType = SigHashAll
+		}
+		if !IsValidSigHashType(sigHashType) {
+			return nil, fmt.Errorf("input %d has an invalid signature hash type: %d", i, sigHashType)
+		}
+		unsigned.TxIns[i] = TxIn{TxOutId: txIn.TxOutId, TxOutIndex: txIn.TxOutIndex, SigHashType: sigHashType}
+		unspentTxOut := FindReferencedTxOut(&txIn, unspentTxOuts)
+		if unspentTxOut == nil {
+			return nil, fmt.Errorf("txOut spent by input %d not found", i)
+		}
+		if unspentTxOut.HashTimeLock != nil || unspentTxOut.LockingScript != "" {
+			return nil, fmt.Errorf("input %d spends a txOut that cannot be signed here", i)
+		}
+		partiallySigned.Inputs[i] = PartiallySignedInput{UnspentTxOut: *unspentTxOut, Signatures: []PartialSignature{}}
+	}
+	unsigned.Id = GetTransactionId(&unsigned)
+	partiallySigned.Transaction = unsigned
+	return &partiallySigned, nil
+}
+
+func ParsePartiallySignedTransaction(encoded string) (*PartiallySignedTransaction, error) {
+	out, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encoding: %s", err.Error())
+	}
+	var partiallySigned PartiallySignedTransaction
+	err = json.Unmarshal(out, &partiallySigned)
+	if err != nil {
+		return nil, err
+	}
+	err = partiallySigned.check()
+	if err != nil {
+		return nil, err
+	}
+	return &partiallySigned, nil
+}
+
+func (partiallySigned *PartiallySignedTransaction) String() string {
+	out, _ := json.Marshal(partiallySigned)
+	return base64.StdEncoding.EncodeToString(out)
+}
+
+// Sign adds a signature for every input key held by the keystore and returns how many were added.
+func (partiallySigned *PartiallySignedTransaction) Sign() (int, error) {
+	added := 0
+	for i := range partiallySigned.Inputs {
+		for _, address := range partiallySigned.Inputs[i].signers() {
+			if !WalletKeystore.HasKey(address) || partiallySigned.Inputs[i].signedBy(address) {
+				continue
+			}
+			privateKey, err := WalletKeystore.GetPrivateKey(address)
+			if err != nil {
+				return added, fmt.Errorf("input %d cannot be signed: %s", i, err.Error())
+			}
+			signingHash, err := partiallySigned.signingHash(i)
+			if err != nil {
+				return added, err
+			}
+			signature, err := SignECDSA(privateKey, signingHash)
+			if err != nil {
+				return added, err
+			}
+			partiallySigned.Inputs[i].Signatures = append(partiallySigned.Inputs[i].Signatures, PartialSignature{
+				PublicKey: GetCompressedAddress(&privateKey.PublicKey),
+				Signature: signature,
+			})
+			added++
+		}
+	}
+	return added, nil
+}
+
+// CombinePartiallySignedTransactions merges the signatures collected by different signers of the same
+// transaction. Signatures that do not verify are refused.
+func CombinePartiallySignedTransactions(partiallySignedTransactions []*PartiallySignedTransaction) (*PartiallySignedTransaction, error) {
+	if len(partiallySignedTransactions) == 0 {
+		return nil, errors.New("nothing to combine")
+	}
+	combined := *partiallySignedTransactions[0]
+	combined.Inputs = make([]PartiallySignedInput, len(partiallySignedTransactions[0].Inputs))
+	for i := range combined.Inputs {
+		combined.Inputs[i] = PartiallySignedInput{
+			UnspentTxOut: partiallySignedTransactions[0].Inputs[i].UnspentTxOut,
+			Signatures:   []PartialSignature{},
+		}
+	}
+	for _, partiallySigned := range partiallySignedTransactions {
+		if partiallySigned.Transaction.Id != combined.Transaction.Id {
+			return nil, errors.New("partially signed transactions spend different transactions")
+		}
+		for i := range partiallySigned.Inputs {
+			if !reflect.DeepEqual(partiallySigned.Inputs[i].UnspentTxOut, combined.Inputs[i].UnspentTxOut) {
+				return nil, fmt.Errorf("input %d spends different txOuts", i)
+			}
+			for _, partialSignature := range partiallySigned.Inputs[i].Signatures {
+				if combined.Inputs[i].signedBy(partialSignature.PublicKey) {
+					continue
+				}
+				err := combined.verifySignature(i, &partialSignature)
+				if err != nil {
+					return nil, err
+				}
+				combined.Inputs[i].Signatures = append(combined.Inputs[i].Signatures, partialSignature)
+			}
+		}
+	}
+	return &combined, nil
+}
+
+// Finalize places the collected signatures in the transaction and validates it against the txOuts it
+// spends.
+func (partiallySigned *PartiallySignedTransaction) Finalize() (*Transaction, error) {
+	missing := partiallySigned.missing()
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("inputs %v are missing signatures", missing)
+	}
+	transaction := partiallySigned.Transaction
+	transaction.TxIns = append([]TxIn{}, partiallySigned.Transaction.TxIns...)
+	unspentTxOuts := []UnspentTxOut{}
+	for i := range partiallySigned.Inputs {
+		input := &partiallySigned.Inputs[i]
+		txIn := &transaction.TxIns[i]
+		unspentTxOuts = append(unspentTxOuts, input.UnspentTxOut)
+		if input.UnspentTxOut.MultiSig != nil {
+			// Signatures are verified in the order of the multisig keys.
+			multiSig := input.UnspentTxOut.MultiSig
+			txIn.Signatures = []string{}
+			for _, publicKey := range multiSig.PublicKeys {
+				if len(txIn.Signatures) == multiSig.Required {
+					break
+				}
+				if partialSignature := input.signatureOf(publicKey); partialSignature != nil {
+					txIn.Signatures = append(txIn.Signatures, partialSignature.Signature)
+				}
+			}
+			continue
+		}
+		partialSignature := input.Signatures[0]
+		txIn.Signature = partialSignature.Signature
+		if IsPublicKeyHashAddress(input.UnspentTxOut.Address) {
+			txIn.PublicKey = partialSignature.PublicKey
+		}
+	}
+	err := CheckTransaction(&transaction, unspentTxOuts)
+	if err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
+
+func (partiallySigned *PartiallySignedTransaction) GetStatus() PartiallySignedStatus {
+	unspentTxOuts := []UnspentTxOut{}
+	for i := range partiallySigned.Inputs {
+		unspentTxOuts = append(unspentTxOuts, partiallySigned.Inputs[i].UnspentTxOut)
+	}
+	missing := partiallySigned.missing()
+	return PartiallySignedStatus{
+		PartiallySigned: partiallySigned.String(),
+		TransactionId:   partiallySigned.Transaction.Id,
+		Fee:             GetTransactionFee(&partiallySigned.Transaction, unspentTxOuts),
+		Complete:        len(missing) == 0,
+		Missing:         missing,
+	}
+}
+
+// check validates a decoded container, so a signer is not misled by txOuts that do not belong to
+// the inputs or by signatures that do not verify.
+func (partiallySigned *PartiallySignedTransaction) check() error {
+	if partiallySigned.Version != PartiallySignedVersion {
+		return fmt.Errorf("unsupported version: %d", partiallySigned.Version)
+	}
+	transaction := &partiallySigned.Transaction
+	if len(transaction.TxIns) == 0 || len(transaction.TxIns) != len(partiallySigned.Inputs) {
+		return errors.New("inputs do not match the transaction")
+	}
+	if transaction.Id != GetTransactionId(transaction) {
+		return errors.New("transaction id does not match")
+	}
+	for i := range transaction.TxIns {
+		txIn := &transaction.TxIns[i]
+		unspentTxOut := &partiallySigned.Inputs[i].UnspentTxOut
+		if unspentTxOut.TxOutId != txIn.TxOutId || unspentTxOut.TxOutIndex != txIn.TxOutIndex {
+			return fmt.Errorf("txOut of input %d does not match", i)
+		}
+		if txIn.SigHashType == SigHashLegacy || !IsValidSigHashType(txIn.SigHashType) {
+			return fmt.Errorf("input %d has an invalid signature hash type: %d", i, txIn.SigHashType)
+		}
+		if txIn.Signature != "" || len(txIn.Signatures) > 0 || txIn.PublicKey != "" {
+			return fmt.Errorf("input %d of the unsigned transaction is signed", i)
+		}
+		signed := make(map[string]bool)
+		for j := range partiallySigned.Inputs[i].Signatures {
+			partialSignature := &partiallySigned.Inputs[i].Signatures[j]
+			publicKey := strings.ToLower(partialSignature.PublicKey)
+			if signed[publicKey] {
+				return fmt.Errorf("input %d is signed more than once by %s", i, partialSignature.PublicKey)
+			}
+			signed[publicKey] = true
+			err := partiallySigned.verifySignature(i, partialSignature)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (partiallySigned *PartiallySignedTransaction) signingHash(inputIndex int) (string, error) {
+	txIn := &partiallySigned.Transaction.TxIns[inputIndex]
+	return GetSignatureHash(&partiallySigned.Transaction, inputIndex, &partiallySigned.Inputs[inputIndex].UnspentTxOut, txIn.SigHashType)
+}
+
+func (partiallySigned *PartiallySignedTransaction) verifySignature(inputIndex int, partialSignature *PartialSignature) error {
+	input := &partiallySigned.Inputs[inputIndex]
+	if !input.isSigner(partialSignature.PublicKey) {
+		return fmt.Errorf("key %s cannot sign input %d", partialSignature.PublicKey, inputIndex)
+	}
+	publicKey, err := GetPublicECDSAKeyFromCompressedAddress(partialSignature.PublicKey)
+	if err != nil {
+		return err
+	}
+	signingHash, err := partiallySigned.signingHash(inputIndex)
+	if err != nil {
+		return err
+	}
+	valid, err := VerifyECDSASignature(publicKey, signingHash, partialSignature.Signature)
+	if err != nil || !valid {
+		return fmt.Errorf("signature of %s on input %d does not verify", partialSignature.PublicKey, inputIndex)
+	}
+	return nil
+}
+
+// missing returns the indexes of the inputs that do not have signatures of enough distinct keys yet.
+func (partiallySigned *PartiallySignedTransaction) missing() []int {
+	missing := []int{}
+	for i := range partiallySigned.Inputs {
+		required := 1
+		if partiallySigned.Inputs[i].UnspentTxOut.MultiSig != nil {
+			required = partiallySigned.Inputs[i].UnspentTxOut.MultiSig.Required
+		}
+		if partiallySigned.Inputs[i].signerCount() < required {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// signerCount returns the number of distinct keys that have signed the input.
+func (input *PartiallySignedInput) signerCount() int {
+	signed := make(map[string]bool)
+	for _, partialSignature := range input.Signatures {
+		signed[strings.ToLower(partialSignature.PublicKey)] = true
+	}
+	return len(signed)
+}
+
+// signers returns the addresses whose keys can sign the input.
+func (input *PartiallySignedInput) signers() []string {
+	if input.UnspentTxOut.MultiSig != nil {
+		return input.UnspentTxOut.MultiSig.PublicKeys
+	}
+	return []string{input.UnspentTxOut.Address}
+}
+
+func (input *PartiallySignedInput) isSigner(publicKey string) bool {
+	for _, address := range input.signers() {
+		if address == publicKey {
+			return true
+		}
+		if IsPublicKeyHashAddress(address) {
+			publicKeyHash, err := GetPublicKeyHash(publicKey)
+			if err == nil && publicKeyHash == address {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// signedBy reports whether the key of address, a public key or its hash, has signed the input.
+func (input *PartiallySignedInput) signedBy(address string) bool {
+	for _, partialSignature := range input.Signatures {
+		if partialSignature.PublicKey == address {
+			return true
+		}
+		publicKeyHash, _ := GetPublicKeyHash(partialSignature.PublicKey)
+		if publicKeyHash == address {
+			return true
+		}
+	}
+	return false
+}
+
+func (input *PartiallySignedInput) signatureOf(publicKey string) *PartialSignature {
+	for i := range input.Signatures {
+		if input.Signatures[i].PublicKey == publicKey {
+			return &input.Signatures[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,531 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Scripts are written as space separated tokens. A token is either an opcode (OP_DUP)
+// or a hex encoded data push. There are no loops or jumps, so every script terminates
+// after at most one pass over its tokens.
+const (
+	MaxScriptTokens   = 201
+	MaxScriptCost     = 2000
+	MaxStackSize      = 1000
+	MaxScriptDataSize = 520
+)
+
+const (
+	OpCostDefault  = 1
+	OpCostHash     = 10
+	OpCostCheckSig = 50
+)
+
+const (
+	OP_0                   = "OP_0"
+	OP_DUP                 = "OP_DUP"
+	OP_DROP                = "OP_DROP"
+	OP_SWAP                = "OP_SWAP"
+	OP_EQUAL               = "OP_EQUAL"
+	OP_EQUALVERIFY         = "OP_EQUALVERIFY"
+	OP_VERIFY              = "OP_VERIFY"
+	OP_RETURN              = "OP_RETURN"
+	OP_IF                  = "OP_IF"
+	OP_NOTIF               = "OP_NOTIF"
+	OP_ELSE                = "OP_ELSE"
+	OP_ENDIF               = "OP_ENDIF"
+	OP_SHA256              = "OP_SHA256"
+	OP_CHECKSIG            = "OP_CHECKSIG"
+	OP_CHECKSIGVERIFY      = "OP_CHECKSIGVERIFY"
+	OP_CHECKMULTISIG       = "OP_CHECKMULTISIG"
+	OP_CHECKLOCKTIMEVERIFY = "OP_CHECKLOCKTIMEVERIFY"
+)
+
+// ScriptContext is the transaction data a script is evaluated against. Signatures are checked
+// against SigningHash, the input's signature digest.
+type ScriptContext struct {
+	Transaction *Transaction
+	InputIndex  int
+	Height      int64
+	SigningHash string
+}
+
+// ScriptStep records the state of the machine after a single token was executed.
+type ScriptStep struct {
+	Script   string   `json:"script"`
+	Position int      `json:"position"`
+	Token    string   `json:"token"`
+	Stack    []string `json:"stack"`
+	Cost     int      `json:"cost"`
+	Executed bool     `json:"executed"`
+}
+
+type ScriptEngine struct {
+	Context   *ScriptContext
+	Stack     [][]byte
+	Cost      int
+	Trace     []ScriptStep
+	traceOn   bool
+	condStack []bool
+}
+
+func NewScriptEngine(context *ScriptContext, trace bool) *ScriptEngine {
+	engine := ScriptEngine{
+		Context: context,
+		Stack:   [][]byte{},
+		Trace:   []ScriptStep{},
+		traceOn: trace,
+	}
+	return &engine
+}
+
+// GetScriptAddress returns the address a script locked output is listed under.
+func GetScriptAddress(lockingScript string) string {
+	return HashString(strings.Join(ParseScriptTokens(lockingScript), " "))
+}
+
+func ParseScriptTokens(script string) []string {
+	return strings.Fields(script)
+}
+
+func IsOpcode(token string) bool {
+	return strings.HasPrefix(token, "OP_")
+}
+
+// IsPushOnlyScript reports whether the script only pushes data, as unlocking scripts must.
+func IsPushOnlyScript(script string) bool {
+	for _, token := range ParseScriptTokens(script) {
+		if IsOpcode(token) && smallIntOpcode(token) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func ValidateLockingScript(lockingScript string) error {
+	tokens := ParseScriptTokens(lockingScript)
+	if len(tokens) == 0 {
+		return errors.New("empty locking script")
+	}
+	if len(tokens) > MaxScriptTokens {
+		return errors.New("locking script has too many tokens")
+	}
+	depth := 0
+	for _, token := range tokens {
+		if !IsOpcode(token) {
+			data, err := hex.DecodeString(token)
+			if err != nil {
+				return fmt.Errorf("invalid data push %s", token)
+			}
+			if len(data) > MaxScriptDataSize {
+				return errors.New("data push exceeds maximum size")
+			}
+			continue
+		}
+		switch token {
+		case OP_IF, OP_NOTIF:
+			depth++
+		case OP_ELSE:
+			if depth == 0 {
+				return errors.New("OP_ELSE without OP_IF")
+			}
+		case OP_ENDIF:
+			if depth == 0 {
+				return errors.New("OP_ENDIF without OP_IF")
+			}
+			depth--
+		default:
+			if !isKnownOpcode(token) {
+				return fmt.Errorf("unknown opcode %s", token)
+			}
+		}
+	}
+	if depth != 0 {
+		return errors.New("unbalanced conditional")
+	}
+	return nil
+}
+
+// VerifyScript runs the unlocking script followed by the locking script and reports
+// whether the spend is authorised.
+func VerifyScript(unlockingScript string, lockingScript string, context *ScriptContext) error {
+	engine := NewScriptEngine(context, false)
+	return engine.Verify(unlockingScript, lockingScript)
+}
+
+// TraceScript is the same as VerifyScript but records every executed step, for wallet developers
+// debugging their scripts.
+func TraceScript(unlockingScript string, lockingScript string, context *ScriptContext) ([]ScriptStep, error) {
+	engine := NewScriptEngine(context, true)
+	err := engine.Verify(unlockingScript, lockingScript)
+	return engine.Trace, err
+}
+
+func (engine *ScriptEngine) Verify(unlockingScript string, lockingScript string) error {
+	if !IsPushOnlyScript(unlockingScript) {
+		return errors.New("unlocking script must only push data")
+	}
+	if err := engine.Execute("unlocking", unlockingScript); err != nil {
+		return err
+	}
+	if err := engine.Execute("locking", lockingScript); err != nil {
+		return err
+	}
+	if len(engine.Stack) == 0 || !castToBool(engine.Stack[len(engine.Stack)-1]) {
+		return errors.New("script evaluated to false")
+	}
+	return nil
+}
+
+func (engine *ScriptEngine) Execute(name string, script string) error {
+	tokens := ParseScriptTokens(script)
+	if len(tokens) > MaxScriptTokens {
+		return errors.New("script has too many tokens")
+	}
+	engine.condStack = []bool{}
+	for i, token := range tokens {
+		executing := engine.executing()
+		err := engine.step(token, executing)
+		if engine.traceOn {
+			engine.Trace = append(engine.Trace, ScriptStep{
+				Script:   name,
+				Position: i,
+				Token:    token,
+				Stack:    engine.stackHex(),
+				Cost:     engine.Cost,
+				Executed: executing,
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("%s script failed at %d (%s): %s", name, i, token, err.Error())
+		}
+		if engine.Cost > MaxScriptCost {
+			return errors.New("script execution cost limit exceeded")
+		}
+		if len(engine.Stack) > MaxStackSize {
+			return errors.New("stack size limit exceeded")
+		}
+	}
+	if len(engine.condStack) != 0 {
+		return errors.New("unbalanced conditional")
+	}
+	return nil
+}
+
+func (engine *ScriptEngine) executing() bool {
+	for _, branch := range engine.condStack {
+		if !branch {
+			return false
+		}
+	}
+	return true
+}
+
+func (engine *ScriptEngine) step(token string, executing bool) error {
+	engine.Cost += OpCostDefault
+	switch token {
+	case OP_IF, OP_NOTIF:
+		branch := false
+		if executing {
+			value, err := engine.pop()
+			if err != nil {
+				return err
+			}
+			branch = castToBool(value)
+			if token == OP_NOTIF {
+				branch = !branch
+			}
+		}
+		engine.condStack = append(engine.condStack, branch)
+		return nil
+	case OP_ELSE:
+		if len(engine.condStack) == 0 {
+			return errors.New("OP_ELSE without OP_IF")
+		}
+		last := len(engine.condStack) - 1
+		engine.condStack[last] = !engine.condStack[last]
+		return nil
+	case OP_ENDIF:
+		if len(engine.condStack) == 0 {
+			return errors.New("OP_ENDIF without OP_IF")
+		}
+		engine.condStack = engine.condStack[:len(engine.condStack)-1]
+		return nil
+	}
+	if !executing {
+		return nil
+	}
+	if !IsOpcode(token) {
+		data, err := hex.DecodeString(token)
+		if err != nil {
+			return fmt.Errorf("invalid data push %s", token)
+		}
+		if len(data) > MaxScriptDataSize {
+			return errors.New("data push exceeds maximum size")
+		}
+		engine.push(data)
+		return nil
+	}
+	if n := smallIntOpcode(token); n >= 0 {
+		engine.push(encodeScriptNumber(int64(n)))
+		return nil
+	}
+	switch token {
+	case OP_DUP:
+		value, err := engine.peek()
+		if err != nil {
+			return err
+		}
+		engine.push(value)
+	case OP_DROP:
+		_, err := engine.pop()
+		return err
+	case OP_SWAP:
+		a, err := engine.pop()
+		if err != nil {
+			return err
+		}
+		b, err := engine.pop()
+		if err != nil {
+			return err
+		}
+		engine.push(a)
+		engine.push(b)
+	case OP_EQUAL, OP_EQUALVERIFY:
+		a, err := engine.pop()
+		if err != nil {
+			return err
+		}
+		b, err := engine.pop()
+		if err != nil {
+			return err
+		}
+		equal := bytes.Equal(a, b)
+		if token == OP_EQUALVERIFY {
+			if !equal {
+				return errors.New("values are not equal")
+			}
+			return nil
+		}
+		engine.push(boolToScript(equal))
+	case OP_VERIFY:
+		value, err := engine.pop()
+		if err != nil {
+			return err
+		}
+		if !castToBool(value) {
+			return errors.New("verify failed")
+		}
+	case OP_RETURN:
+		return errors.New("OP_RETURN executed")
+	case OP_SHA256:
+		engine.Cost += OpCostHash
+		value, err := engine.pop()
+		if err != nil {
+			return err
+		}
+		hashed := sha256.Sum256(value)
+		engine.push(hashed[:])
+	case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+		engine.Cost += OpCostCheckSig
+		publicKey, err := engine.pop()
+		if err != nil {
+			return err
+		}
+		signature, err := engine.pop()
+		if err != nil {
+			return err
+		}
+		validated := engine.checkSignature(publicKey, signature)
+		if token == OP_CHECKSIGVERIFY {
+			if !validated {
+				return errors.New("signature verification failed")
+			}
+			return nil
+		}
+		engine.push(boolToScript(validated))
+	case OP_CHECKMULTISIG:
+		return engine.checkMultiSig()
+	case OP_CHECKLOCKTIMEVERIFY:
+		value, err := engine.peek()
+		if err != nil {
+			return err
+		}
+		lockHeight, err := decodeScriptNumber(value)
+		if err != nil {
+			return err
+		}
+		if engine.Context == nil || engine.Context.Height < lockHeight {
+			return fmt.Errorf("output is locked until height %d", lockHeight)
+		}
+	default:
+		return fmt.Errorf("unknown opcode %s", token)
+	}
+	return nil
+}
+
+// checkMultiSig expects <sig1> ... <sigM> <M> <key1> ... <keyN> <N> on the stack.
+// As with MultiSig outputs, signatures must be in the same order as their keys.
+func (engine *ScriptEngine) checkMultiSig() error {
+	value, err := engine.pop()
+	if err != nil {
+		return err
+	}
+	keyCount, err := decodeScriptNumber(value)
+	if err != nil {
+		return err
+	}
+	if keyCount < 1 || keyCount > MaxMultiSigKeys {
+		return errors.New("invalid multisig key count")
+	}
+	engine.Cost += OpCostCheckSig * int(keyCount)
+	publicKeys := make([][]byte, keyCount)
+	for i := keyCount - 1; i >= 0; i-- {
+		publicKeys[i], err = engine.pop()
+		if err != nil {
+			return err
+		}
+	}
+	value, err = engine.pop()
+	if err != nil {
+		return err
+	}
+	required, err := decodeScriptNumber(value)
+	if err != nil {
+		return err
+	}
+	if required < 1 || required > keyCount {
+		return errors.New("invalid multisig threshold")
+	}
+	signatures := make([][]byte, required)
+	for i := required - 1; i >= 0; i-- {
+		signatures[i], err = engine.pop()
+		if err != nil {
+			return err
+		}
+	}
+	keyIndex := 0
+	for i := range signatures {
+		matched := false
+		for keyIndex < len(publicKeys) {
+			validated := engine.checkSignature(publicKeys[keyIndex], signatures[i])
+			keyIndex++
+			if validated {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			engine.push(boolToScript(false))
+			return nil
+		}
+	}
+	engine.push(boolToScript(true))
+	return nil
+}
+
+func (engine *ScriptEngine) checkSignature(publicKeyBytes []byte, signatureBytes []byte) bool {
+	if engine.Context == nil || engine.Context.SigningHash == "" {
+		return false
+	}
+	publicKey, err := GetPublicECDSAKeyFromCompressedAddress(hex.EncodeToString(publicKeyBytes))
+	if err != nil {
+		return false
+	}
+	validated, err := VerifyECDSASignature(publicKey, engine.Context.SigningHash, hex.EncodeToString(signatureBytes))
+	if err != nil {
+		return false
+	}
+	return validated
+}
+
+func (engine *ScriptEngine) push(value []byte) {
+	engine.Stack = append(engine.Stack, value)
+}
+
+func (engine *ScriptEngine) pop() ([]byte, error) {
+	if len(engine.Stack) == 0 {
+		return nil, errors.New("stack underflow")
+	}
+	value := engine.Stack[len(engine.Stack)-1]
+	engine.Stack = engine.Stack[:len(engine.Stack)-1]
+	return value, nil
+}
+
+func (engine *ScriptEngine) peek() ([]byte, error) {
+	if len(engine.Stack) == 0 {
+		return nil, errors.New("stack underflow")
+	}
+	return engine.Stack[len(engine.Stack)-1], nil
+}
+
+func (engine *ScriptEngine) stackHex() []string {
+	stack := make([]string, len(engine.Stack))
+	for i := range engine.Stack {
+		stack[i] = hex.EncodeToString(engine.Stack[i])
+	}
+	return stack
+}
+
+func isKnownOpcode(token string) bool {
+	if smallIntOpcode(token) >= 0 {
+		return true
+	}
+	switch token {
+	case OP_DUP, OP_DROP, OP_SWAP, OP_EQUAL, OP_EQUALVERIFY, OP_VERIFY, OP_RETURN,
+		OP_IF, OP_NOTIF, OP_ELSE, OP_ENDIF, OP_SHA256, OP_CHECKSIG, OP_CHECKSIGVERIFY,
+		OP_CHECKMULTISIG, OP_CHECKLOCKTIMEVERIFY:
+		return true
+	}
+	return false
+}
+
+// smallIntOpcode returns n for OP_0 through OP_16 and -1 for anything else.
+func smallIntOpcode(token string) int {
+	if token == OP_0 {
+		return 0
+	}
+	var n int
+	_, err := fmt.Sscanf(token, "OP_%d", &n)
+	if err != nil || n < 1 || n > 16 || token != fmt.Sprintf("OP_%d", n) {
+		return -1
+	}
+	return n
+}
+
+// Script numbers are unsigned big-endian integers of at most 8 bytes.
+func encodeScriptNumber(n int64) []byte {
+	return big.NewInt(n).Bytes()
+}
+
+func decodeScriptNumber(value []byte) (int64, error) {
+	if len(value) > 8 {
+		return 0, errors.New("script number overflow")
+	}
+	number := new(big.Int).SetBytes(value)
+	if !number.IsInt64() {
+		return 0, errors.New("script number overflow")
+	}
+	return number.Int64(), nil
+}
+
+func castToBool(value []byte) bool {
+	for _, b := range value {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func boolToScript(value bool) []byte {
+	if value {
+		return []byte{1}
+	}
+	return []byte{}
+}
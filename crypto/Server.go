@@ -2,11 +2,14 @@ package crypto
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 type Params struct {
@@ -26,6 +29,7 @@ const (
 	QUERY_LATEST = iota
 	QUERY_ALL
 	RESPONSE_BLOCKCHAIN
+	RESPONSE_TRANSACTION_REPLACED
 )
 
 type Message struct {
@@ -64,8 +68,12 @@ func LatestBlock(w http.ResponseWriter, r *http.Request) {
 }
 
 func Unspent(w http.ResponseWriter, r *http.Request) {
+	unspentTxOuts := GetAllUnspentTxOuts()
+	if r.URL.Query().Get("mempool") == "true" {
+		unspentTxOuts = GetUnspentTxOutsWithPool()
+	}
 	w.Header().Set("Content-Type", "application/json")
-	err := json.NewEncoder(w).Encode(GetAllUnspentTxOuts())
+	err := json.NewEncoder(w).Encode(unspentTxOuts)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -74,7 +82,60 @@ func Unspent(w http.ResponseWriter, r *http.Request) {
 
 func GetTransactionPool(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	err := json.NewEncoder(w).Encode(TransactionPool)
+	err := json.NewEncoder(w).Encode(TransactionPool.GetTransactions())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func GetOrphanPool(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(Orphans.GetEntries())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func EstimateFee(w http.ResponseWriter, r *http.Request) {
+	target, err := strconv.Atoi(r.URL.Query().Get("target"))
+	if err != nil {
+		http.Error(w, "target must be a number of blocks", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	feeRate, err := FeeEstimates.EstimateFee(target)
+	if err != nil {
+		err := json.NewEncoder(w).Encode(ErrorResponse{Message: err.Error()})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	err = json.NewEncoder(w).Encode(FeeEstimate{Target: target, FeeRate: feeRate})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+type MempoolInfo struct {
+	Transactions int64 `json:"transactions"`
+	Size         int64 `json:"size"`
+	MaxSize      int64 `json:"maxSize"`
+	MinFeeRate   int64 `json:"minFeeRate"`
+}
+
+func GetMempoolInfo(w http.ResponseWriter, r *http.Request) {
+	info := MempoolInfo{
+		Transactions: int64(len(TransactionPool.GetEntries())),
+		Size:         TransactionPool.Size(),
+		MaxSize:      TransactionPool.MaxSize,
+		MinFeeRate:   TransactionPool.GetMinFeeRate(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(info)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -105,30 +166,98 @@ func RemoveElementFromSlice(slice []UnspentTxOut, index int) []UnspentTxOut {
 	return append(slice[:index], slice[index+1:]...)
 }
 
+// GetUnspentTxOutsOfTransaction returns the spendable outputs created by transaction, indexed by
+// their position in TxOuts.
+func GetUnspentTxOutsOfTransaction (transaction *Transaction) []UnspentTxOut {
+	var unspentTxOuts = []UnspentTxOut{}
+	for k := range transaction.TxOuts {
+		txOut := transaction.TxOuts[k]
+		if IsDataTxOut(&txOut) {
+			continue
+		}
+		unspentTxOuts = append(unspentTxOuts, UnspentTxOut{
+			TxOutId:       transaction.Id,
+			TxOutIndex:    int64(k),
+			Address:       txOut.Address,
+			Amount:        txOut.Amount,
+			MultiSig:      txOut.MultiSig,
+			LockingScript: txOut.LockingScript,
+			HashTimeLock:  txOut.HashTimeLock,
+		})
+	}
+	return unspentTxOuts
+}
+
+// UpdateUnspentTxOuts applies transactions in order, removing the txOuts they spend and adding the ones they create.
+func UpdateUnspentTxOuts (transactions []Transaction, unspentTxOuts []UnspentTxOut) []UnspentTxOut {
+	spent := make(map[string]bool)
+	var created = []UnspentTxOut{}
+	for i := range transactions {
+		for j := range transactions[i].TxIns {
+			txIn := transactions[i].TxIns[j]
+			spent[GetOutpoint(txIn.TxOutId, txIn.TxOutIndex)] = true
+		}
+		created = append(created, GetUnspentTxOutsOfTransaction(&transactions[i])...)
+	}
+	var updated = []UnspentTxOut{}
+	for _, txOut := range append(unspentTxOuts, created...) {
+		if !spent[GetOutpoint(txOut.TxOutId, txOut.TxOutIndex)] {
+			updated = append(updated, txOut)
+		}
+	}
+	return updated
+}
+
+// OutputIndexHeight is the first block height whose outputs are indexed by their position in TxOuts.
+// Chains built before then indexed every output of a transaction by the TxOutIndex of its first txIn,
+// which for a coinbase is the block height, and their spends reference those indexes. Blocks below
+// the height are still applied that way. Every node of a network must use the same height.
+var OutputIndexHeight int64
+
+// UpdateUnspentTxOutsAtHeight applies the transactions of the block at blockIndex with the indexing
+// rules in force at that height.
+func UpdateUnspentTxOutsAtHeight (transactions []Transaction, unspentTxOuts []UnspentTxOut, blockIndex int64) []UnspentTxOut {
+	if blockIndex < OutputIndexHeight {
+		return UpdateLegacyUnspentTxOuts(transactions, unspentTxOuts)
+	}
+	return UpdateUnspentTxOuts(transactions, unspentTxOuts)
+}
+
+// UpdateLegacyUnspentTxOuts applies transactions the way blocks below OutputIndexHeight were: an
+// output replaces the unspent txOut of the same address that the first txIn spends, and is indexed
+// by that txIn's TxOutIndex.
+func UpdateLegacyUnspentTxOuts (transactions []Transaction, unspentTxOuts []UnspentTxOut) []UnspentTxOut {
+	for j := range transactions {
+		transaction := transactions[j]
+		txIn := transaction.TxIns[0]
+		for k := range transaction.TxOuts {
+			txOut := transaction.TxOuts[k]
+			if IsDataTxOut(&txOut) {
+				continue
+			}
+			index := HasTxOutIdWithAddress(txIn.TxOutId, txOut.Address, unspentTxOuts)
+			if index != -1 {
+				unspentTxOuts = RemoveElementFromSlice(unspentTxOuts, index)
+			}
+			unspentTxOuts = append(unspentTxOuts, UnspentTxOut{
+				TxOutId:       transaction.Id,
+				TxOutIndex:    txIn.TxOutIndex,
+				Address:       txOut.Address,
+				Amount:        txOut.Amount,
+				MultiSig:      txOut.MultiSig,
+				LockingScript: txOut.LockingScript,
+				HashTimeLock:  txOut.HashTimeLock,
+			})
+		}
+	}
+	return unspentTxOuts
+}
+
 func GetAllUnspentTxOuts () []UnspentTxOut {
 	var unspentTxOuts = []UnspentTxOut{}
 	blockChain := GetBlockChain()
 	for i, _ := range blockChain {
-		block := blockChain[i]
-		transactions := block.Data
-		for j, _ := range transactions {
-			transaction := transactions[j]
-			txOuts := transaction.TxOuts
-			for k, _ := range txOuts {
-				txOut := txOuts[k]
-				txIn := transaction.TxIns[0]
-				index := HasTxOutIdWithAddress(txIn.TxOutId, txOut.Address, unspentTxOuts)
-				if index != -1 {
-					unspentTxOuts = RemoveElementFromSlice(unspentTxOuts, index)
-				}
-				unspentTxOuts = append(unspentTxOuts, UnspentTxOut{
-					TxOutId:    transaction.Id,
-					TxOutIndex: txIn.TxOutIndex,
-					Address:    txOut.Address,
-					Amount:     txOut.Amount,
-				})
-			}
-		}
+		unspentTxOuts = UpdateUnspentTxOutsAtHeight(blockChain[i].Data, unspentTxOuts, blockChain[i].Index)
 	}
 	return unspentTxOuts
 }
@@ -142,38 +271,22 @@ func GetBalanceOfUnspentTxOuts (txOuts []UnspentTxOut) int64 {
 }
 
 func GetUnspentTxOutsOfAddress(address string) []UnspentTxOut {
-	var unspentTxOuts = []UnspentTxOut{}
-	blockChain := GetBlockChain()
-	for i, _ := range blockChain {
-		block := blockChain[i]
-		transactions := block.Data
-		for j, _ := range transactions {
-			transaction := transactions[j]
-			txOuts := transaction.TxOuts
-			for k, _ := range txOuts {
-				txOut := txOuts[k]
-				if txOut.Address == address {
-					txIn := transaction.TxIns[0]
-					index := HasTxOutId(txIn.TxOutId, unspentTxOuts)
-					if index != -1 {
-						unspentTxOuts = RemoveElementFromSlice(unspentTxOuts, index)
-					}
-					unspentTxOuts = append(unspentTxOuts, UnspentTxOut{
-						TxOutId:    transaction.Id,
-						TxOutIndex: txIn.TxOutIndex,
-						Address:    txOut.Address,
-						Amount:     txOut.Amount,
-					})
-				}
-			}
+	return FilterUnspentTxOutsOfAddress(GetAllUnspentTxOuts(), address)
+}
+
+func FilterUnspentTxOutsOfAddress(unspentTxOuts []UnspentTxOut, address string) []UnspentTxOut {
+	var filtered = []UnspentTxOut{}
+	for i := range unspentTxOuts {
+		if unspentTxOuts[i].Address == address {
+			filtered = append(filtered, unspentTxOuts[i])
 		}
 	}
-	return unspentTxOuts
+	return filtered
 }
 
 func Address(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	address := vars["hash"]
+	address := NormalizeAddress(vars["hash"])
 	unspentTxOuts := GetUnspentTxOutsOfAddress(address)
 	w.Header().Set("Content-Type", "application/json")
 	err := json.NewEncoder(w).Encode(unspentTxOuts)
@@ -183,6 +296,138 @@ func Address(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func DataCarriers(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	payload := vars["payload"]
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(FindDataCarriers(payload))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+type HTLCCreateParams struct {
+	TxIns        []TxIn       `json:"txIns"`
+	HashTimeLock HashTimeLock `json:"hashTimeLock"`
+	Amount       int64        `json:"amount"`
+	Change       []TxOut      `json:"change"`
+}
+
+type HTLCSpendParams struct {
+	TxOutId    string `json:"txOutId"`
+	TxOutIndex int64  `json:"txOutIndex"`
+	ToAddress  string `json:"toAddress"`
+	Fee        int64  `json:"fee"`
+	Preimage   string `json:"preimage"`
+}
+
+// CreateHTLC returns an unsigned transaction funding a hash time locked output. The caller signs
+// its inputs and submits it through /api/sendTransaction.
+func CreateHTLC(w http.ResponseWriter, r *http.Request) {
+	var params HTLCCreateParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	params.HashTimeLock.RecipientAddress = NormalizeAddress(params.HashTimeLock.RecipientAddress)
+	params.HashTimeLock.RefundAddress = NormalizeAddress(params.HashTimeLock.RefundAddress)
+	params.HashTimeLock.SecretHash = strings.ToLower(params.HashTimeLock.SecretHash)
+	for i := range params.Change {
+		params.Change[i].Address = NormalizeAddress(params.Change[i].Address)
+	}
+	if !ValidateHashTimeLock(&params.HashTimeLock) {
+		err := json.NewEncoder(w).Encode(ErrorResponse{Message: "Invalid hash time lock"})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	txOuts := []TxOut{NewHashTimeLockTxOut(&params.HashTimeLock, params.Amount)}
+	txOuts = append(txOuts, params.Change...)
+	transaction := &Transaction{
+		Version: TransactionVersion2,
+		TxIns:   params.TxIns,
+		TxOuts:  txOuts,
+	}
+	transaction.Id = GetTransactionId(transaction)
+	err = json.NewEncoder(w).Encode(transaction)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func RedeemHTLC(w http.ResponseWriter, r *http.Request) {
+	spendHTLC(w, r, true)
+}
+
+func RefundHTLC(w http.ResponseWriter, r *http.Request) {
+	spendHTLC(w, r, false)
+}
+
+// spendHTLC returns the unsigned redeem or refund transaction for a hash time locked output
+// along with the digest the spender has to sign.
+func spendHTLC(w http.ResponseWriter, r *http.Request, redeem bool) {
+	var params HTLCSpendParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !redeem {
+		params.Preimage = ""
+	} else if params.Preimage == "" {
+		http.Error(w, "preimage is required to redeem", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	txIn := TxIn{TxOutId: params.TxOutId, TxOutIndex: params.TxOutIndex}
+	unspentTxOut := FindReferencedTxOut(&txIn, GetAllUnspentTxOuts())
+	if unspentTxOut == nil {
+		err := json.NewEncoder(w).Encode(NotFound{Message: "Unspent txOut not found"})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	spend, err := BuildHashTimeLockSpend(unspentTxOut, params.ToAddress, params.Fee, params.Preimage)
+	if err != nil {
+		err := json.NewEncoder(w).Encode(ErrorResponse{Message: err.Error()})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	err = json.NewEncoder(w).Encode(spend)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func HTLCPreimage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	found, preimages := FindRevealedPreimages(id)
+	w.Header().Set("Content-Type", "application/json")
+	if found {
+		err := json.NewEncoder(w).Encode(preimages)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		return
+	} else {
+		err := json.NewEncoder(w).Encode(NotFound{Message: "Transaction not found"})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}
+}
+
 type Wallet struct {
 	Alias string `json:"alias"`
 	Address string `json:"address"`
@@ -215,7 +460,7 @@ func Status(w http.ResponseWriter, r *http.Request) {
 	wallets := []Wallet{}
 	for key, value := range duplicates {
 		wallets = append(wallets, Wallet{
-			Alias:               "",
+			Alias:               WatchOnlyWallets.GetLabel(key),
 			Address:             key,
 			Balance:             GetBalanceOfUnspentTxOuts(GetUnspentTxOutsOfAddress(key)),
 			UnspentTransactions: int64(value),
@@ -294,8 +539,11 @@ func GetBlock(w http.ResponseWriter, r *http.Request) {
 
 type MineParams struct {
 	Transactions []Transaction `json:"transactions"`
+	Address      string        `json:"address"`
 }
 
+// MineBlock mines the posted transactions, or a block assembled from the transaction pool when none
+// are posted. Assembled blocks pay the coinbase to the posted address or the configured payout address.
 func MineBlock(w http.ResponseWriter, r *http.Request) {
 	var params MineParams
 	err := json.NewDecoder(r.Body).Decode(&params)
@@ -304,6 +552,18 @@ func MineBlock(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if len(params.Transactions) == 0 {
+		address := params.Address
+		if address == "" {
+			address = PayoutAddress
+		}
+		template, err := CreateBlockTemplate(address)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		params.Transactions = template.Transactions
+	}
 	added, block := GenerateNextBlock(params.Transactions)
 	if added {
 		w.Header().Set("Content-Type", "application/json")
@@ -320,8 +580,27 @@ func MineBlock(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func GetBlockTemplate(w http.ResponseWriter, r *http.Request) {
+	address := r.URL.Query().Get("address")
+	if address == "" {
+		address = PayoutAddress
+	}
+	template, err := CreateBlockTemplate(address)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(template)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
 type SendTransactionStruct struct {
 	Transaction Transaction `json:"transaction"`
+	// Deprecated: inputs are resolved against the node's own unspent txOuts and this list is ignored.
 	UnspentTxOuts []UnspentTxOut `json:"unspentTxOuts"`
 }
 
@@ -333,12 +612,13 @@ func SendTransaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	err = AddToTransactionPool(params.Transaction, params.UnspentTxOuts)
+	err = AddToTransactionPool(params.Transaction)
 	if err != nil {
-		err := json.NewEncoder(w).Encode(NotFound{Message: err.Error()})
+		err := json.NewEncoder(w).Encode(ErrorResponse{Message: err.Error()})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 		}
+		return
 	}
 	found, tx := GetTransactionById(params.Transaction.Id)
 	if found {
@@ -355,6 +635,127 @@ func SendTransaction(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type SendPackageParams struct {
+	Transactions []Transaction `json:"transactions"`
+}
+
+// SendPackage submits a child together with its unconfirmed parents so the child can pay for them.
+func SendPackage(w http.ResponseWriter, r *http.Request) {
+	var params SendPackageParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = AddPackageToTransactionPool(params.Transactions)
+	if err != nil {
+		err := json.NewEncoder(w).Encode(ErrorResponse{Message: err.Error()})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	accepted := []Transaction{}
+	for i := range params.Transactions {
+		found, tx := GetTransactionById(params.Transactions[i].Id)
+		if found {
+			accepted = append(accepted, tx)
+		}
+	}
+	err = json.NewEncoder(w).Encode(accepted)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// TestAccept checks a transaction against the node's current state and pool policy without adding
+// it to the pool or broadcasting it.
+func TestAccept(w http.ResponseWriter, r *http.Request) {
+	var params SendTransactionStruct
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(TestAcceptTransaction(params.Transaction))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+type ScriptDebugParams struct {
+	Transaction     *Transaction `json:"transaction"`
+	InputIndex      int          `json:"inputIndex"`
+	UnlockingScript string       `json:"unlockingScript"`
+	LockingScript   string       `json:"lockingScript"`
+}
+
+type ScriptDebugResponse struct {
+	Valid bool         `json:"valid"`
+	Error string       `json:"error,omitempty"`
+	Cost  int          `json:"cost"`
+	Trace []ScriptStep `json:"trace"`
+}
+
+// DebugScript executes a script pair step by step and returns the trace. Scripts that are not given
+// explicitly are taken from the transaction input and the output it spends.
+func DebugScript(w http.ResponseWriter, r *http.Request) {
+	var params ScriptDebugParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	context := &ScriptContext{
+		Transaction: params.Transaction,
+		InputIndex:  params.InputIndex,
+		Height:      GetNextBlockHeight(),
+	}
+	if params.Transaction != nil {
+		if params.InputIndex < 0 || params.InputIndex >= len(params.Transaction.TxIns) {
+			http.Error(w, "input index out of range", http.StatusBadRequest)
+			return
+		}
+		txIn := params.Transaction.TxIns[params.InputIndex]
+		if params.UnlockingScript == "" {
+			params.UnlockingScript = txIn.UnlockingScript
+		}
+		referencedTxOut := FindReferencedTxOut(&txIn, GetAllUnspentTxOuts())
+		if referencedTxOut != nil {
+			if params.LockingScript == "" {
+				params.LockingScript = referencedTxOut.LockingScript
+			}
+			signingHash, err := GetTxInSigningHash(&txIn, params.Transaction, referencedTxOut)
+			if err == nil {
+				context.SigningHash = signingHash
+			}
+		} else if txIn.SigHashType == SigHashLegacy {
+			context.SigningHash = params.Transaction.Id
+		}
+	}
+	trace, err := TraceScript(params.UnlockingScript, params.LockingScript, context)
+	response := ScriptDebugResponse{
+		Valid: err == nil,
+		Trace: trace,
+	}
+	if err != nil {
+		response.Error = err.Error()
+	}
+	if len(trace) > 0 {
+		response.Cost = trace[len(trace)-1].Cost
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
 func HandleMessages()  {
 	for {
 		msg := <-broadcast
@@ -401,3 +802,394 @@ func HandleWSConnections(w http.ResponseWriter, r *http.Request) {
 		broadcast <- msg
 	}
 }
+
+type KeystoreParams struct {
+	Passphrase string `json:"passphrase"`
+	Timeout    int64  `json:"timeout"`
+}
+
+type ImportKeyParams struct {
+	PrivateKey string `json:"privateKey"`
+}
+
+type AddressResponse struct {
+	Address string `json:"address"`
+	Encoded string `json:"encoded,omitempty"`
+}
+
+func NewAddressResponse(address string) AddressResponse {
+	encoded, _ := EncodeAddress(address)
+	return AddressResponse{Address: address, Encoded: encoded}
+}
+
+// writeWalletResponse encodes result, or err as an ErrorResponse when it is set.
+func writeWalletResponse(w http.ResponseWriter, result interface{}, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		result = ErrorResponse{Message: err.Error()}
+	}
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func CreateWallet(w http.ResponseWriter, r *http.Request) {
+	var params KeystoreParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = WalletKeystore.Create(params.Passphrase, params.Timeout)
+	writeWalletResponse(w, WalletKeystore.GetStatus(), err)
+}
+
+func UnlockWallet(w http.ResponseWriter, r *http.Request) {
+	var params KeystoreParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = WalletKeystore.Unlock(params.Passphrase, params.Timeout)
+	writeWalletResponse(w, WalletKeystore.GetStatus(), err)
+}
+
+func LockWallet(w http.ResponseWriter, r *http.Request) {
+	WalletKeystore.Lock()
+	writeWalletResponse(w, WalletKeystore.GetStatus(), nil)
+}
+
+func GetWalletKeys(w http.ResponseWriter, r *http.Request) {
+	writeWalletResponse(w, WalletKeystore.GetStatus(), nil)
+}
+
+// NewWalletAddress returns a new public key address, or its public key hash address with ?type=pubkeyhash.
+func NewWalletAddress(w http.ResponseWriter, r *http.Request) {
+	address, err := WalletKeystore.NewKey()
+	if err == nil && r.URL.Query().Get("type") == "pubkeyhash" {
+		address, err = GetPublicKeyHash(address)
+	}
+	writeWalletResponse(w, NewAddressResponse(address), err)
+}
+
+// ImportWalletKey adds a PEM encoded EC private key to the keystore.
+func ImportWalletKey(w http.ResponseWriter, r *http.Request) {
+	var params ImportKeyParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	privateKey, err := ParseEcdsaPrivateKeyFromPem(params.PrivateKey)
+	if err != nil {
+		writeWalletResponse(w, nil, err)
+		return
+	}
+	address, err := WalletKeystore.AddKey(privateKey)
+	writeWalletResponse(w, NewAddressResponse(address), err)
+}
+
+type DeriveAddressesParams struct {
+	ExtendedKey string `json:"extendedKey"`
+	Path        string `json:"path"`
+	Start       uint32 `json:"start"`
+	Count       uint32 `json:"count"`
+}
+
+// DeriveHDAddresses lists the addresses below an extended key, so receive addresses can be derived
+// from an extended public key without access to the private keys.
+func DeriveHDAddresses(w http.ResponseWriter, r *http.Request) {
+	var params DeriveAddressesParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	addresses, err := DeriveAddresses(params.ExtendedKey, params.Path, params.Start, params.Count)
+	writeWalletResponse(w, addresses, err)
+}
+
+// SendFromWallet builds, signs and submits a payment from an address in the keystore.
+func SendFromWallet(w http.ResponseWriter, r *http.Request) {
+	var params SendParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	transaction, err := Send(params.From, params.To, params.Amount, params.FeeRate, params.ChangeAddress)
+	writeWalletResponse(w, transaction, err)
+}
+
+func GetAddressValidation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(ValidateAddress(vars["address"]))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+type WatchOnlyWalletParams struct {
+	Name string `json:"name"`
+}
+
+type WatchParams struct {
+	Address     string `json:"address"`
+	ExtendedKey string `json:"extendedKey"`
+	Label       string `json:"label"`
+}
+
+func CreateWatchOnlyWallet(w http.ResponseWriter, r *http.Request) {
+	var params WatchOnlyWalletParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = WatchOnlyWallets.Create(params.Name)
+	if err != nil {
+		writeWalletResponse(w, nil, err)
+		return
+	}
+	wallet, err := WatchOnlyWallets.GetWallet(params.Name)
+	writeWalletResponse(w, wallet, err)
+}
+
+func GetWatchOnlyWallets(w http.ResponseWriter, r *http.Request) {
+	writeWalletResponse(w, WatchOnlyWallets.GetWallets(), nil)
+}
+
+// GetWatchOnlyWallet returns the balance, txOuts and history of a watch-only wallet.
+func GetWatchOnlyWallet(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	wallet, err := WatchOnlyWallets.GetWallet(vars["name"])
+	writeWalletResponse(w, wallet, err)
+}
+
+// ImportWatched adds an address or an extended public key to a watch-only wallet. Importing an
+// address or key that is already watched changes its label.
+func ImportWatched(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var params WatchParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case params.Address != "" && params.ExtendedKey != "":
+		err = errors.New("import either an address or an extended key")
+	case params.Address != "":
+		err = WatchOnlyWallets.ImportAddress(vars["name"], params.Address, params.Label)
+	case params.ExtendedKey != "":
+		err = WatchOnlyWallets.ImportExtendedKey(vars["name"], params.ExtendedKey, params.Label)
+	default:
+		err = errors.New("address or extended key required")
+	}
+	if err != nil {
+		writeWalletResponse(w, nil, err)
+		return
+	}
+	wallet, err := WatchOnlyWallets.GetWallet(vars["name"])
+	writeWalletResponse(w, wallet, err)
+}
+
+type SignMessageParams struct {
+	Address string `json:"address"`
+	Message string `json:"message"`
+}
+
+// SignWalletMessage signs a message with the keystore key of an address to prove control of it.
+func SignWalletMessage(w http.ResponseWriter, r *http.Request) {
+	var params SignMessageParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	signedMessage, err := SignMessage(params.Address, params.Message)
+	writeWalletResponse(w, signedMessage, err)
+}
+
+func VerifySignedMessage(w http.ResponseWriter, r *http.Request) {
+	var signedMessage SignedMessage
+	err := json.NewDecoder(r.Body).Decode(&signedMessage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	verification := MessageVerification{Valid: true, Address: NormalizeAddress(signedMessage.Address)}
+	err = VerifyMessage(&signedMessage)
+	if err != nil {
+		verification = MessageVerification{Valid: false, Message: err.Error()}
+	}
+	writeWalletResponse(w, verification, nil)
+}
+
+// NewWalletMnemonic gives the keystore a seed from a new mnemonic for a paper backup.
+func NewWalletMnemonic(w http.ResponseWriter, r *http.Request) {
+	var params MnemonicParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	response, err := WalletKeystore.NewMnemonic(params.Words, params.Passphrase)
+	writeWalletResponse(w, response, err)
+}
+
+// RestoreWallet recovers the keys of a mnemonic that the chain shows were used.
+func RestoreWallet(w http.ResponseWriter, r *http.Request) {
+	var params RestoreParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result, err := WalletKeystore.Restore(params.Mnemonic, params.Passphrase, params.GapLimit)
+	writeWalletResponse(w, result, err)
+}
+
+type RescanParams struct {
+	FromHeight int64 `json:"fromHeight"`
+}
+
+func AddressHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(GetAddressHistory(vars["hash"]))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// GetWalletHistory lists the transactions of the keystore keys with their confirmations and net amount.
+func GetWalletHistory(w http.ResponseWriter, r *http.Request) {
+	writeWalletResponse(w, WatchOnlyWallets.GetKeystoreWallet(), nil)
+}
+
+// RescanWallet rebuilds the keystore history from a height, after keys were imported.
+func RescanWallet(w http.ResponseWriter, r *http.Request) {
+	var params RescanParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	wallet, err := WatchOnlyWallets.Rescan("", params.FromHeight)
+	writeWalletResponse(w, wallet, err)
+}
+
+func RescanWatchOnlyWallet(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var params RescanParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	wallet, err := WatchOnlyWallets.Rescan(vars["name"], params.FromHeight)
+	writeWalletResponse(w, wallet, err)
+}
+
+type CreatePartiallySignedParams struct {
+	Transaction Transaction `json:"transaction"`
+}
+
+// readPartiallySigned decodes the partially signed transaction in the request body.
+func readPartiallySigned(r *http.Request) (*PartiallySignedTransaction, error) {
+	var params PartiallySignedParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePartiallySignedTransaction(params.PartiallySigned)
+}
+
+func CreatePartiallySigned(w http.ResponseWriter, r *http.Request) {
+	var params CreatePartiallySignedParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	partiallySigned, err := CreatePartiallySignedTransaction(params.Transaction)
+	if err != nil {
+		writeWalletResponse(w, nil, err)
+		return
+	}
+	writeWalletResponse(w, partiallySigned.GetStatus(), nil)
+}
+
+func DecodePartiallySigned(w http.ResponseWriter, r *http.Request) {
+	partiallySigned, err := readPartiallySigned(r)
+	writeWalletResponse(w, partiallySigned, err)
+}
+
+// SignPartiallySigned adds the signatures of the keystore keys to a partially signed transaction.
+func SignPartiallySigned(w http.ResponseWriter, r *http.Request) {
+	partiallySigned, err := readPartiallySigned(r)
+	if err == nil {
+		_, err = partiallySigned.Sign()
+	}
+	if err != nil {
+		writeWalletResponse(w, nil, err)
+		return
+	}
+	writeWalletResponse(w, partiallySigned.GetStatus(), nil)
+}
+
+func CombinePartiallySigned(w http.ResponseWriter, r *http.Request) {
+	var params CombineParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	partiallySignedTransactions := []*PartiallySignedTransaction{}
+	for _, encoded := range params.PartiallySigned {
+		partiallySigned, err := ParsePartiallySignedTransaction(encoded)
+		if err != nil {
+			writeWalletResponse(w, nil, err)
+			return
+		}
+		partiallySignedTransactions = append(partiallySignedTransactions, partiallySigned)
+	}
+	combined, err := CombinePartiallySignedTransactions(partiallySignedTransactions)
+	if err != nil {
+		writeWalletResponse(w, nil, err)
+		return
+	}
+	writeWalletResponse(w, combined.GetStatus(), nil)
+}
+
+// FinalizePartiallySigned returns the signed transaction once every input has its signatures.
+func FinalizePartiallySigned(w http.ResponseWriter, r *http.Request) {
+	partiallySigned, err := readPartiallySigned(r)
+	if err != nil {
+		writeWalletResponse(w, nil, err)
+		return
+	}
+	transaction, err := partiallySigned.Finalize()
+	writeWalletResponse(w, transaction, err)
+}
+
+// BroadcastPartiallySigned finalizes a partially signed transaction and submits it to the pool.
+func BroadcastPartiallySigned(w http.ResponseWriter, r *http.Request) {
+	partiallySigned, err := readPartiallySigned(r)
+	if err != nil {
+		writeWalletResponse(w, nil, err)
+		return
+	}
+	transaction, err := partiallySigned.Finalize()
+	if err == nil {
+		err = AddToTransactionPool(*transaction)
+	}
+	writeWalletResponse(w, transaction, err)
+}
@@ -0,0 +1,98 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Signature hash types select which parts of a transaction an input signature commits to.
+// A TxIn without a SigHashType is a legacy input whose signatures are over the transaction id.
+const (
+	SigHashLegacy       = 0
+	SigHashAll          = 1
+	SigHashNone         = 2
+	SigHashSingle       = 3
+	SigHashAnyoneCanPay = 0x80
+)
+
+func IsValidSigHashType(hashType int) bool {
+	if hashType == SigHashLegacy {
+		return true
+	}
+	base := hashType &^ SigHashAnyoneCanPay
+	return base == SigHashAll || base == SigHashNone || base == SigHashSingle
+}
+
+// GetSignatureHash returns the digest signed by the input at inputIndex. Besides the parts of the
+// transaction selected by hashType it always commits to the spent output's address and amount, and
+// to the input index unless hashType has SigHashAnyoneCanPay, so that inputs signed on their own can
+// be combined into one transaction in any order. Every field is length prefixed, so no two different
+// transactions produce the same preimage.
+func GetSignatureHash(transaction *Transaction, inputIndex int, spentTxOut *UnspentTxOut, hashType int) (string, error) {
+	if inputIndex < 0 || inputIndex >= len(transaction.TxIns) {
+		return "", errors.New("input index out of range")
+	}
+	if !IsValidSigHashType(hashType) || hashType == SigHashLegacy {
+		return "", fmt.Errorf("invalid signature hash type %d", hashType)
+	}
+	if spentTxOut == nil {
+		return "", errors.New("spent txOut is required")
+	}
+	var builder strings.Builder
+	if GetTransactionVersion(transaction) != TransactionVersion1 {
+		writeSigHashField(&builder, fmt.Sprintf("v%d", transaction.Version))
+	}
+	writeSigHashField(&builder, strconv.Itoa(hashType))
+
+	if hashType&SigHashAnyoneCanPay != 0 {
+		txIn := transaction.TxIns[inputIndex]
+		writeSigHashField(&builder, txIn.TxOutId)
+		writeSigHashField(&builder, strconv.FormatInt(txIn.TxOutIndex, 10))
+	} else {
+		writeSigHashField(&builder, strconv.Itoa(inputIndex))
+		for i := range transaction.TxIns {
+			writeSigHashField(&builder, transaction.TxIns[i].TxOutId)
+			writeSigHashField(&builder, strconv.FormatInt(transaction.TxIns[i].TxOutIndex, 10))
+		}
+	}
+
+	switch hashType &^ SigHashAnyoneCanPay {
+	case SigHashAll:
+		for i := range transaction.TxOuts {
+			writeSigHashTxOut(&builder, &transaction.TxOuts[i])
+		}
+	case SigHashSingle:
+		if inputIndex >= len(transaction.TxOuts) {
+			return "", errors.New("no txOut matches the input index for SIGHASH_SINGLE")
+		}
+		writeSigHashTxOut(&builder, &transaction.TxOuts[inputIndex])
+	}
+
+	writeSigHashField(&builder, spentTxOut.Address)
+	writeSigHashField(&builder, strconv.FormatInt(spentTxOut.Amount, 10))
+	return HashString(builder.String()), nil
+}
+
+func writeSigHashTxOut(builder *strings.Builder, txOut *TxOut) {
+	writeSigHashField(builder, txOut.Address)
+	writeSigHashField(builder, strconv.FormatInt(txOut.Amount, 10))
+	data := ""
+	if IsDataTxOut(txOut) {
+		data = txOut.Data
+	}
+	writeSigHashField(builder, data)
+}
+
+func writeSigHashField(builder *strings.Builder, field string) {
+	builder.WriteString(fmt.Sprintf("%d:%s", len(field), field))
+}
+
+// GetTxInSigningHash returns the digest the signatures of txIn are verified against.
+func GetTxInSigningHash(txIn *TxIn, transaction *Transaction, spentTxOut *UnspentTxOut) (string, error) {
+	if txIn.SigHashType == SigHashLegacy {
+		return transaction.Id, nil
+	}
+	return GetSignatureHash(transaction, GetTxInIndex(txIn, transaction), spentTxOut, txIn.SigHashType)
+}
@@ -0,0 +1,91 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Signed messages prove control of an address without moving funds. The digest is taken over
+// MessageMagic followed by the message; no transaction digest starts with it, so a signed message can
+// never be replayed as a signature for spending coins.
+const MessageMagic = "Chacoin Signed Message:\n"
+
+// SignedMessage carries the public key for public key hash addresses, which the signature cannot be
+// verified without.
+type SignedMessage struct {
+	Address   string `json:"address"`
+	Message   string `json:"message"`
+	Signature string `json:"signature"`
+	PublicKey string `json:"publicKey,omitempty"`
+}
+
+type MessageVerification struct {
+	Valid   bool   `json:"valid"`
+	Address string `json:"address,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func GetMessageHash(message string) string {
+	return HashString(MessageMagic + message)
+}
+
+// SignMessage signs message with the keystore key of address. The wallet must be unlocked.
+func SignMessage(address string, message string) (*SignedMessage, error) {
+	decoded, err := DecodeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := WalletKeystore.GetPrivateKey(decoded)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := SignECDSA(privateKey, GetMessageHash(message))
+	if err != nil {
+		return nil, err
+	}
+	signedMessage := SignedMessage{
+		Address:   address,
+		Message:   message,
+		Signature: signature,
+	}
+	if IsPublicKeyHashAddress(decoded) {
+		signedMessage.PublicKey = GetCompressedAddress(&privateKey.PublicKey)
+	}
+	return &signedMessage, nil
+}
+
+// VerifyMessage checks that the signature over message was made by the key of address.
+func VerifyMessage(signedMessage *SignedMessage) error {
+	address, err := DecodeAddress(signedMessage.Address)
+	if err != nil {
+		return err
+	}
+	publicKey := address
+	if IsPublicKeyHashAddress(address) {
+		if signedMessage.PublicKey == "" {
+			return errors.New("public key required for a public key hash address")
+		}
+		publicKeyHash, err := GetPublicKeyHash(signedMessage.PublicKey)
+		if err != nil {
+			return fmt.Errorf("invalid public key: %s", err.Error())
+		}
+		if publicKeyHash != address {
+			return errors.New("public key does not match the address hash")
+		}
+		publicKey = signedMessage.PublicKey
+	} else if signedMessage.PublicKey != "" && signedMessage.PublicKey != address {
+		return errors.New("public key does not match the address")
+	}
+	key, err := GetPublicECDSAKeyFromCompressedAddress(publicKey)
+	if err != nil {
+		return err
+	}
+	valid, err := VerifyECDSASignature(key, GetMessageHash(signedMessage.Message), signedMessage.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %s", err.Error())
+	}
+	if !valid {
+		return errors.New("signature does not match the message")
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package crypto
 import (
 	"errors"
 	"fmt"
+	"math"
 	"strings"
 )
 
@@ -10,11 +11,26 @@ const (
 	CoinBaseAmount      = 100
 )
 
+// Transaction versions. Version 1 is the original format, whose ids do not commit to the version,
+// and is assumed when no version is set. Version 2 ids commit to the version and its inputs may
+// exceed its outputs, the difference being a fee collected by the coinbase.
+// Versions above MaxKnownTransactionVersion are consensus-valid under the newest known rules but
+// are not standard, so they are not accepted into the pool until a soft fork defines them.
+const (
+	TransactionVersion1           = 1
+	TransactionVersion2           = 2
+	MaxKnownTransactionVersion    = TransactionVersion2
+	MaxStandardTransactionVersion = TransactionVersion2
+)
+
 type UnspentTxOut struct {
-	TxOutId    string `json:"txOutId"`
-	TxOutIndex int64  `json:"txOutIndex"`
-	Address    string `json:"address"`
-	Amount     int64  `json:"amount"`
+	TxOutId       string        `json:"txOutId"`
+	TxOutIndex    int64         `json:"txOutIndex"`
+	Address       string        `json:"address"`
+	Amount        int64         `json:"amount"`
+	MultiSig      *MultiSig     `json:"multiSig,omitempty"`
+	LockingScript string        `json:"lockingScript,omitempty"`
+	HashTimeLock  *HashTimeLock `json:"hashTimeLock,omitempty"`
 }
 
 func NewUnspentTxOut(txOutId string, txOutIndex int64, address string, amount int64) *UnspentTxOut {
@@ -28,20 +44,31 @@ func NewUnspentTxOut(txOutId string, txOutIndex int64, address string, amount in
 }
 
 type TxIn struct {
-	TxOutId    string `json:"txOutId"`
-	TxOutIndex int64 `json:"txOutIndex"`
-	Signature  string `json:"signature"`
+	TxOutId         string   `json:"txOutId"`
+	TxOutIndex      int64    `json:"txOutIndex"`
+	Signature       string   `json:"signature"`
+	Signatures      []string `json:"signatures,omitempty"`
+	UnlockingScript string   `json:"unlockingScript,omitempty"`
+	SigHashType     int      `json:"sigHashType,omitempty"`
+	Preimage        string   `json:"preimage,omitempty"`
+	// PublicKey is the key a public key hash address commits to, revealed when the output is spent.
+	PublicKey string `json:"publicKey,omitempty"`
 }
 
 type TxOut struct {
-	Address string `json:"address"`
-	Amount  int64  `json:"amount"`
+	Address       string        `json:"address"`
+	Amount        int64         `json:"amount"`
+	MultiSig      *MultiSig     `json:"multiSig,omitempty"`
+	LockingScript string        `json:"lockingScript,omitempty"`
+	HashTimeLock  *HashTimeLock `json:"hashTimeLock,omitempty"`
+	Data          string        `json:"data,omitempty"`
 }
 
 type Transaction struct {
-	Id     string  `json:"id"`
-	TxIns  []TxIn  `json:"txIns"`
-	TxOuts []TxOut `json:"txOuts"`
+	Id      string  `json:"id"`
+	Version int     `json:"version,omitempty"`
+	TxIns   []TxIn  `json:"txIns"`
+	TxOuts  []TxOut `json:"txOuts"`
 }
 
 func NewTransaction(id string, txIns []TxIn, txOuts []TxOut) *Transaction {
@@ -53,33 +80,143 @@ func NewTransaction(id string, txIns []TxIn, txOuts []TxOut) *Transaction {
 	return &transaction
 }
 
+func GetTransactionVersion (transaction *Transaction) int {
+	if transaction.Version == 0 {
+		return TransactionVersion1
+	}
+	return transaction.Version
+}
+
 func GetTransactionId (transaction *Transaction) string {
 	var inBuilder, outBuilder strings.Builder
+	if GetTransactionVersion(transaction) != TransactionVersion1 {
+		inBuilder.WriteString(fmt.Sprintf("v%d", transaction.Version))
+	}
 	for i := range transaction.TxIns {
 		inBuilder.WriteString(fmt.Sprintf("%s%d", transaction.TxIns[i].TxOutId, transaction.TxIns[i].TxOutIndex))
 	}
 	for j := range transaction.TxOuts {
-		outBuilder.WriteString(fmt.Sprintf("%s%d", transaction.TxOuts[j].Address, transaction.TxOuts[j].Amount))
+		outBuilder.WriteString(SerializeTxOut(&transaction.TxOuts[j]))
 	}
 	combined := fmt.Sprintf("%s%s", inBuilder.String(), outBuilder.String())
 	hashed := HashString(combined)
 	return hashed
 }
 
+// SerializeTxOut returns the part of a txOut committed to by transaction ids and signature hashes.
+func SerializeTxOut (txOut *TxOut) string {
+	serialized := fmt.Sprintf("%s%d", txOut.Address, txOut.Amount)
+	if IsDataTxOut(txOut) {
+		serialized += fmt.Sprintf("data%s", txOut.Data)
+	}
+	return serialized
+}
+
+type TransactionValidator func(transaction *Transaction, unspentTxOuts []UnspentTxOut) error
+
+var TransactionValidators = map[int]TransactionValidator{
+	TransactionVersion1: CheckTransactionV1,
+	TransactionVersion2: CheckTransactionV2,
+}
+
 func ValidateTransaction (transaction *Transaction, unspentTxOuts []UnspentTxOut) bool {
-	if GetTransactionId(transaction) != transaction.Id {
-		fmt.Printf("Transaction ids do not match\n")
+	err := CheckTransaction(transaction, unspentTxOuts)
+	if err != nil {
+		fmt.Printf("Invalid transaction: %s\n", err.Error())
 		return false
 	}
+	return true
+}
+
+// CheckTransaction applies the consensus rules of the transaction's version and returns the first
+// rule it breaks.
+func CheckTransaction (transaction *Transaction, unspentTxOuts []UnspentTxOut) error {
+	version := GetTransactionVersion(transaction)
+	if version < TransactionVersion1 {
+		return fmt.Errorf("invalid transaction version: %d", version)
+	}
+	validator, exists := TransactionValidators[version]
+	if !exists {
+		validator = TransactionValidators[MaxKnownTransactionVersion]
+	}
+	return validator(transaction, unspentTxOuts)
+}
+
+func CheckTransactionV1 (transaction *Transaction, unspentTxOuts []UnspentTxOut) error {
+	err := CheckTransactionStructure(transaction, unspentTxOuts)
+	if err != nil {
+		return err
+	}
+	totalTxInValues, totalTxOutValues := GetTransactionValues(transaction, unspentTxOuts)
+	if totalTxInValues != totalTxOutValues {
+		return fmt.Errorf("total txIn and txOut values do not match. TxIns: %d TxOuts: %d", totalTxInValues, totalTxOutValues)
+	}
+	return nil
+}
+
+func CheckTransactionV2 (transaction *Transaction, unspentTxOuts []UnspentTxOut) error {
+	err := CheckTransactionStructure(transaction, unspentTxOuts)
+	if err != nil {
+		return err
+	}
+	totalTxInValues, totalTxOutValues := GetTransactionValues(transaction, unspentTxOuts)
+	if totalTxInValues < totalTxOutValues {
+		return fmt.Errorf("total txOut value exceeds txIn value. TxIns: %d TxOuts: %d", totalTxInValues, totalTxOutValues)
+	}
+	return nil
+}
+
+// CheckTransactionStructure holds the rules shared by every transaction version.
+func CheckTransactionStructure (transaction *Transaction, unspentTxOuts []UnspentTxOut) error {
+	if GetTransactionId(transaction) != transaction.Id {
+		return errors.New("transaction ids do not match")
+	}
+	if HasDuplicates(transaction.TxIns) {
+		return errors.New("transaction spends the same txOut more than once")
+	}
+	// Validation of TxOuts
+	for i := range transaction.TxOuts {
+		if !ValidateTxOut(&transaction.TxOuts[i]) {
+			return fmt.Errorf("txOut %d is not valid", i)
+		}
+	}
+	_, err := SumTxOutValues(transaction.TxOuts)
+	if err != nil {
+		return err
+	}
 	// Validation of TxIns
+	var totalTxInValues int64
 	for i := range transaction.TxIns {
-		validated := ValidateTxIn(&transaction.TxIns[i], transaction, unspentTxOuts)
-		if !validated {
-			fmt.Printf("txin is not valid: %+v\n", &transaction.TxIns[i])
-			return false
+		err := CheckTxIn(&transaction.TxIns[i], transaction, unspentTxOuts)
+		if err != nil {
+			return fmt.Errorf("txIn %d is not valid: %s", i, err.Error())
+		}
+		amount := FindReferencedTxOut(&transaction.TxIns[i], unspentTxOuts).Amount
+		if amount < 0 || totalTxInValues > math.MaxInt64-amount {
+			return errors.New("total txIn value out of range")
+		}
+		totalTxInValues += amount
+	}
+	return nil
+}
+
+// SumTxOutValues returns the total amount of txOuts, refusing negative amounts and totals that do
+// not fit an int64. Without this a negative output would raise the fee a transaction pays.
+func SumTxOutValues (txOuts []TxOut) (int64, error) {
+	var total int64
+	for i := range txOuts {
+		if txOuts[i].Amount < 0 {
+			return 0, fmt.Errorf("txOut %d has a negative amount", i)
 		}
+		if total > math.MaxInt64-txOuts[i].Amount {
+			return 0, errors.New("total txOut value out of range")
+		}
+		total += txOuts[i].Amount
 	}
+	return total, nil
+}
 
+func GetTransactionValues (transaction *Transaction, unspentTxOuts []UnspentTxOut) (int64, int64) {
 	var totalTxInValues int64
 	var totalTxOutValues int64
 
@@ -93,13 +230,13 @@ func ValidateTransaction (transaction *Transaction, unspentTxOuts []UnspentTxOut
 	for i := range transaction.TxOuts {
 		totalTxOutValues += transaction.TxOuts[i].Amount
 	}
+	return totalTxInValues, totalTxOutValues
+}
 
-	if totalTxInValues != totalTxOutValues {
-		fmt.Printf("Total txIn and txOut valued do not match. TxIns: %d TxOuts: %d\n", totalTxInValues, totalTxOutValues)
-		return false
-	}
-
-	return true
+// GetTransactionFee returns the value of the inputs not claimed by the outputs.
+func GetTransactionFee (transaction *Transaction, unspentTxOuts []UnspentTxOut) int64 {
+	totalTxInValues, totalTxOutValues := GetTransactionValues(transaction, unspentTxOuts)
+	return totalTxInValues - totalTxOutValues
 }
 
 func FindReferencedTxOut (txIn *TxIn, unspentTxOuts []UnspentTxOut) *UnspentTxOut {
@@ -111,24 +248,124 @@ func FindReferencedTxOut (txIn *TxIn, unspentTxOuts []UnspentTxOut) *UnspentTxOu
 	return nil
 }
 
-func ValidateTxIn (txIn *TxIn, transaction *Transaction, unspentTxOuts []UnspentTxOut) bool {
+func ValidateTxOut (txOut *TxOut) bool {
+	if txOut.Amount < 0 {
+		fmt.Printf("TxOut amount must not be negative\n")
+		return false
+	}
+	if IsDataTxOut(txOut) {
+		return ValidateDataTxOut(txOut)
+	}
+	if txOut.MultiSig != nil {
+		if !ValidateMultiSig(txOut.MultiSig) {
+			return false
+		}
+		if txOut.Address != GetMultiSigAddress(txOut.MultiSig) {
+			fmt.Printf("Multisig txOut address does not match its public keys\n")
+			return false
+		}
+	}
+	if txOut.LockingScript != "" {
+		if txOut.MultiSig != nil {
+			fmt.Printf("TxOut cannot have both a multisig and a locking script\n")
+			return false
+		}
+		err := ValidateLockingScript(txOut.LockingScript)
+		if err != nil {
+			fmt.Printf("Invalid locking script: %s\n", err.Error())
+			return false
+		}
+		if txOut.Address != GetScriptAddress(txOut.LockingScript) {
+			fmt.Printf("Script txOut address does not match its locking script\n")
+			return false
+		}
+	}
+	if txOut.HashTimeLock != nil {
+		if txOut.MultiSig != nil || txOut.LockingScript != "" {
+			fmt.Printf("TxOut cannot combine a hash time lock with other locks\n")
+			return false
+		}
+		if !ValidateHashTimeLock(txOut.HashTimeLock) {
+			return false
+		}
+		if txOut.Address != GetHashTimeLockAddress(txOut.HashTimeLock) {
+			fmt.Printf("Hash time locked txOut address does not match its lock\n")
+			return false
+		}
+	}
+	return true
+}
+
+// CheckTxIn verifies that txIn unlocks the txOut it references and returns why it does not.
+func CheckTxIn (txIn *TxIn, transaction *Transaction, unspentTxOuts []UnspentTxOut) error {
 	referencedTxOut := FindReferencedTxOut(txIn, unspentTxOuts)
 	if referencedTxOut == nil {
-		fmt.Printf("Referenced txOut not found")
-		return false
+		return errors.New("referenced txOut not found")
+	}
+	if !IsValidSigHashType(txIn.SigHashType) {
+		return fmt.Errorf("invalid signature hash type: %d", txIn.SigHashType)
+	}
+	signingHash, err := GetTxInSigningHash(txIn, transaction, referencedTxOut)
+	if err != nil {
+		return fmt.Errorf("signature hash could not be computed: %s", err.Error())
+	}
+	if referencedTxOut.MultiSig != nil {
+		if !VerifyMultiSig(referencedTxOut.MultiSig, signingHash, txIn.Signatures) {
+			return errors.New("multisig signatures could not be verified")
+		}
+		return nil
+	}
+	if referencedTxOut.HashTimeLock != nil {
+		if !VerifyHashTimeLock(referencedTxOut.HashTimeLock, txIn, signingHash, GetNextBlockHeight()) {
+			return errors.New("hash time lock could not be unlocked")
+		}
+		return nil
+	}
+	if referencedTxOut.LockingScript != "" {
+		context := &ScriptContext{
+			Transaction: transaction,
+			InputIndex:  GetTxInIndex(txIn, transaction),
+			Height:      GetNextBlockHeight(),
+			SigningHash: signingHash,
+		}
+		err := VerifyScript(txIn.UnlockingScript, referencedTxOut.LockingScript, context)
+		if err != nil {
+			return fmt.Errorf("script could not be verified: %s", err.Error())
+		}
+		return nil
 	}
 	address := referencedTxOut.Address
+	if IsPublicKeyHashAddress(address) {
+		publicKeyHash, err := GetPublicKeyHash(txIn.PublicKey)
+		if err != nil {
+			return fmt.Errorf("invalid public key: %s", err.Error())
+		}
+		if publicKeyHash != address {
+			return errors.New("public key does not match the address hash")
+		}
+		address = txIn.PublicKey
+	}
 	publicKey, err := GetPublicECDSAKeyFromCompressedAddress(address)
 	if err != nil {
-		fmt.Printf("Public key could not be derived from address: %s\n", err.Error())
-		return false
+		return fmt.Errorf("public key could not be derived from address: %s", err.Error())
 	}
-	validated, err := VerifyECDSASignature(publicKey, transaction.Id, txIn.Signature)
+	validated, err := VerifyECDSASignature(publicKey, signingHash, txIn.Signature)
 	if err != nil {
-		fmt.Printf("Signature could not be verified: %s\n", err.Error())
-		return false
+		return fmt.Errorf("signature could not be verified: %s", err.Error())
+	}
+	if !validated {
+		return errors.New("signature does not match")
 	}
-	return validated
+	return nil
+}
+
+func GetTxInIndex (txIn *TxIn, transaction *Transaction) int {
+	for i := range transaction.TxIns {
+		if transaction.TxIns[i].TxOutId == txIn.TxOutId && transaction.TxIns[i].TxOutIndex == txIn.TxOutIndex {
+			return i
+		}
+	}
+	return -1
 }
 
 func ProcessTransactions (transactions []Transaction, unspentTxOuts []UnspentTxOut, blockIndex int64) (bool, error) {
@@ -138,9 +375,23 @@ func ProcessTransactions (transactions []Transaction, unspentTxOuts []UnspentTxO
 	return true, nil
 }
 
+// ValidateBlockTransactions validates the transactions in block order, so a transaction may spend
+// the outputs of one earlier in the same block.
 func ValidateBlockTransactions (transactions []Transaction, unspentTxOuts []UnspentTxOut, blockIndex int64) bool {
+	var fees int64
+	normalTransactions := transactions[1:]
+	blockUnspentTxOuts := unspentTxOuts
+	for i := range normalTransactions {
+		fee := GetTransactionFee(&normalTransactions[i], blockUnspentTxOuts)
+		if fee < 0 || fees > math.MaxInt64-CoinBaseAmount-fee {
+			fmt.Printf("Invalid fee in transaction %s\n", normalTransactions[i].Id)
+			return false
+		}
+		fees += fee
+		blockUnspentTxOuts = UpdateUnspentTxOutsAtHeight(normalTransactions[i:i+1], blockUnspentTxOuts, blockIndex)
+	}
 	coinBaseTx := transactions[0]
-	if !ValidateCoinBaseTx(&coinBaseTx, blockIndex) {
+	if !ValidateCoinBaseTx(&coinBaseTx, blockIndex, fees) {
 		fmt.Printf("Invalid coinbase transaction: %+v\n", coinBaseTx)
 		return false
 	}
@@ -156,11 +407,11 @@ func ValidateBlockTransactions (transactions []Transaction, unspentTxOuts []Unsp
 		return false
 	}
 
-	normalTransactions := transactions[1:]
 	for _, tx := range normalTransactions {
 		if !ValidateTransaction(&tx, unspentTxOuts) {
 			return false
 		}
+		unspentTxOuts = UpdateUnspentTxOutsAtHeight([]Transaction{tx}, unspentTxOuts, blockIndex)
 	}
 	return true
 }
@@ -179,7 +430,7 @@ func HasDuplicates (txIns []TxIn) bool {
 	return false
 }
 
-func ValidateCoinBaseTx (transaction *Transaction, blockIndex int64) bool {
+func ValidateCoinBaseTx (transaction *Transaction, blockIndex int64, fees int64) bool {
 	if transaction == nil {
 		fmt.Printf("Transaction is nil\n")
 		return false
@@ -196,9 +447,16 @@ func ValidateCoinBaseTx (transaction *Transaction, blockIndex int64) bool {
 		fmt.Printf("the txin signature in coinbase tx must be the block height\n")
 		return false
 	}
-	if transaction.TxOuts[0].Amount != CoinBaseAmount {
+	total, err := SumTxOutValues(transaction.TxOuts)
+	if err != nil || len(transaction.TxOuts) == 0 || total != CoinBaseAmount+fees {
 		fmt.Printf("Invalid coinbase amount in coinbase transaction\n")
 		return false
 	}
+	for i := range transaction.TxOuts {
+		if !ValidateTxOut(&transaction.TxOuts[i]) {
+			fmt.Printf("Invalid txOut in coinbase transaction: %+v\n", &transaction.TxOuts[i])
+			return false
+		}
+	}
 	return true
 }
\ No newline at end of file
@@ -0,0 +1,178 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultConfirmTarget is the number of blocks the fee of a wallet transaction aims for when no fee
+// rate is given.
+const DefaultConfirmTarget = 6
+
+// placeholderSignature has the length of the longest ASN.1 P-256 signature, so sizes estimated with
+// it are never below the size of the signed transaction.
+var (
+	placeholderSignature = strings.Repeat("00", 72)
+	placeholderPublicKey = "02" + strings.Repeat("00", 32)
+)
+
+type SendParams struct {
+	From          string `json:"from"`
+	To            string `json:"to"`
+	Amount        int64  `json:"amount"`
+	FeeRate       int64  `json:"feeRate"`
+	ChangeAddress string `json:"changeAddress"`
+}
+
+// Send pays amount from an address in the keystore to another address and submits the transaction
+// to the pool. Without a fee rate the estimate for DefaultConfirmTarget blocks is used. Change goes to
+// changeAddress, or to a new change key of the keystore when it is empty.
+func Send(from string, to string, amount int64, feeRate int64, changeAddress string) (*Transaction, error) {
+	if feeRate <= 0 {
+		estimate, err := FeeEstimates.EstimateFee(DefaultConfirmTarget)
+		if err != nil {
+			estimate = TransactionPool.GetMinFeeRate()
+		}
+		feeRate = estimate
+	}
+	from, err := DecodeAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address: %s", err.Error())
+	}
+	unspentTxOuts := FilterUnspentTxOutsOfAddress(GetUnspentTxOutsWithPool(), from)
+	transaction, spent, err := BuildTransaction(unspentTxOuts, to, amount, feeRate, changeAddress)
+	if err != nil {
+		return nil, err
+	}
+	err = SignTransaction(transaction, spent)
+	if err != nil {
+		return nil, err
+	}
+	err = AddToTransactionPool(*transaction)
+	if err != nil {
+		return nil, err
+	}
+	return transaction, nil
+}
+
+// BuildTransaction selects txOuts to pay amount to address at feeRate and returns the unsigned
+// transaction with the txOuts it spends. The largest txOuts are spent first. Change above zero goes to
+// changeAddress, or to a new change key of the keystore when changeAddress is empty.
+func BuildTransaction(unspentTxOuts []UnspentTxOut, address string, amount int64, feeRate int64, changeAddress string) (*Transaction, []UnspentTxOut, error) {
+	if amount <= 0 {
+		return nil, nil, errors.New("amount must be positive")
+	}
+	address, err := DecodeAddress(address)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid recipient address: %s", err.Error())
+	}
+	if changeAddress != "" {
+		changeAddress, err = DecodeAddress(changeAddress)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid change address: %s", err.Error())
+		}
+	}
+	candidates := []UnspentTxOut{}
+	for i := range unspentTxOuts {
+		if isPlainTxOut(&unspentTxOuts[i]) {
+			candidates = append(candidates, unspentTxOuts[i])
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Amount > candidates[j].Amount
+	})
+
+	transaction := &Transaction{
+		Version: TransactionVersion2,
+		TxIns:   []TxIn{},
+		TxOuts:  []TxOut{{Address: address, Amount: amount}},
+	}
+	var total int64
+	for i := range candidates {
+		transaction.TxIns = append(transaction.TxIns, TxIn{
+			TxOutId:     candidates[i].TxOutId,
+			TxOutIndex:  candidates[i].TxOutIndex,
+			SigHashType: SigHashAll,
+		})
+		total += candidates[i].Amount
+		fee := GetRequiredFee(feeRate, estimateSignedSize(transaction, candidates))
+		if total < amount+fee {
+			continue
+		}
+		// A change output makes the transaction larger, so it is only added if the change still
+		// covers the extra fee.
+		withChange := *transaction
+		withChange.TxOuts = append(append([]TxOut{}, transaction.TxOuts...), TxOut{Address: placeholderChangeAddress(changeAddress), Amount: total})
+		change := total - amount - GetRequiredFee(feeRate, estimateSignedSize(&withChange, candidates))
+		if change > 0 {
+			if changeAddress == "" {
+				changeAddress, err = WalletKeystore.NewChangeKey()
+				if err != nil {
+					return nil, nil, fmt.Errorf("change address could not be created: %s", err.Error())
+				}
+			}
+			transaction.TxOuts = append(transaction.TxOuts, TxOut{Address: changeAddress, Amount: change})
+		}
+		transaction.Id = GetTransactionId(transaction)
+		return transaction, candidates[:i+1], nil
+	}
+	return nil, nil, fmt.Errorf("insufficient funds: %d available, %d plus fee needed", total, amount)
+}
+
+// SignTransaction signs every input of transaction with the keystore key of the txOut it spends,
+// committing to all inputs and outputs.
+func SignTransaction(transaction *Transaction, spent []UnspentTxOut) error {
+	for i := range transaction.TxIns {
+		txIn := &transaction.TxIns[i]
+		unspentTxOut := FindReferencedTxOut(txIn, spent)
+		if unspentTxOut == nil {
+			return fmt.Errorf("txOut spent by input %d is unknown", i)
+		}
+		privateKey, err := WalletKeystore.GetPrivateKey(unspentTxOut.Address)
+		if err != nil {
+			return fmt.Errorf("input %d cannot be signed: %s", i, err.Error())
+		}
+		txIn.SigHashType = SigHashAll
+		if IsPublicKeyHashAddress(unspentTxOut.Address) {
+			txIn.PublicKey = GetCompressedAddress(&privateKey.PublicKey)
+		}
+		signingHash, err := GetSignatureHash(transaction, i, unspentTxOut, SigHashAll)
+		if err != nil {
+			return err
+		}
+		txIn.Signature, err = SignECDSA(privateKey, signingHash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isPlainTxOut reports whether a txOut is locked to a single public key and can be spent with a signature.
+func isPlainTxOut(unspentTxOut *UnspentTxOut) bool {
+	return unspentTxOut.MultiSig == nil && unspentTxOut.LockingScript == "" && unspentTxOut.HashTimeLock == nil
+}
+
+func estimateSignedSize(transaction *Transaction, spent []UnspentTxOut) int64 {
+	estimated := *transaction
+	estimated.Id = GetTransactionId(transaction)
+	estimated.TxIns = make([]TxIn, len(transaction.TxIns))
+	for i := range transaction.TxIns {
+		estimated.TxIns[i] = transaction.TxIns[i]
+		estimated.TxIns[i].Signature = placeholderSignature
+		unspentTxOut := FindReferencedTxOut(&transaction.TxIns[i], spent)
+		if unspentTxOut != nil && IsPublicKeyHashAddress(unspentTxOut.Address) {
+			estimated.TxIns[i].PublicKey = placeholderPublicKey
+		}
+	}
+	return GetTransactionSize(&estimated)
+}
+
+func placeholderChangeAddress(changeAddress string) string {
+	if changeAddress != "" {
+		return changeAddress
+	}
+	return placeholderPublicKey
+}
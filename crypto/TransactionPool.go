@@ -1,69 +1,446 @@
 package crypto
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
 )
 
-var TransactionPool []Transaction = []Transaction{}
+// Fee rates are expressed in coins per 1000 bytes of JSON encoded transaction.
+const (
+	DefaultMaxPoolSize      = 5000000
+	DefaultPoolExpiry       = 14 * 24 * 60 * 60
+	DefaultMinRelayFeeRate  = 0
+	IncrementalRelayFeeRate = 1
+	RollingFeeRateHalfLife  = 12 * 60 * 60
+)
+
+// Limits on chains of unconfirmed transactions. Counts and sizes include the transaction itself.
+const (
+	MaxAncestorCount   = 25
+	MaxAncestorSize    = 101000
+	MaxDescendantCount = 25
+	MaxDescendantSize  = 101000
+	MaxPackageCount    = MaxAncestorCount
+	MaxPackageSize     = MaxAncestorSize
+)
+
+// PoolEntry is a pending transaction. The ancestor and descendant totals describe the package of
+// unconfirmed transactions it depends on or that depend on it, and are filled in by GetEntries.
+type PoolEntry struct {
+	Transaction     Transaction `json:"transaction"`
+	Fee             int64       `json:"fee"`
+	Size            int64       `json:"size"`
+	FeeRate         int64       `json:"feeRate"`
+	Time            int64       `json:"time"`
+	Height          int64       `json:"height"`
+	AncestorCount   int64       `json:"ancestorCount"`
+	AncestorSize    int64       `json:"ancestorSize"`
+	AncestorFees    int64       `json:"ancestorFees"`
+	DescendantCount int64       `json:"descendantCount"`
+	DescendantSize  int64       `json:"descendantSize"`
+	DescendantFees  int64       `json:"descendantFees"`
+
+	parents  map[string]*PoolEntry
+	children map[string]*PoolEntry
+}
+
+// TxPool holds the pending transactions indexed by id and ordered by fee rate. When the pool grows
+// beyond MaxSize bytes the lowest fee rate entries are evicted and the minimum fee rate for new
+// entries is raised above theirs, decaying back to MinRelayFeeRate over time.
+type TxPool struct {
+	MaxSize         int64
+	Expiry          int64
+	MinRelayFeeRate int64
+
+	mutex                  sync.Mutex
+	entries                map[string]*PoolEntry
+	byFeeRate              []*PoolEntry
+	size                   int64
+	rollingMinFeeRate      float64
+	lastRollingFeeRateTime int64
+}
+
+var TransactionPool = NewTxPool(DefaultMaxPoolSize, DefaultPoolExpiry, DefaultMinRelayFeeRate)
+
+func NewTxPool(maxSize int64, expiry int64, minRelayFeeRate int64) *TxPool {
+	pool := TxPool{
+		MaxSize:         maxSize,
+		Expiry:          expiry,
+		MinRelayFeeRate: minRelayFeeRate,
+		entries:         make(map[string]*PoolEntry),
+		byFeeRate:       []*PoolEntry{},
+	}
+	return &pool
+}
+
+func GetTransactionSize (transaction *Transaction) int64 {
+	out, err := json.Marshal(transaction)
+	if err != nil {
+		return 0
+	}
+	return int64(len(out))
+}
+
+func GetFeeRate (fee int64, size int64) int64 {
+	if size <= 0 {
+		return 0
+	}
+	return fee * 1000 / size
+}
+
+func NewPoolEntry (transaction Transaction, unspentTxOuts []UnspentTxOut) *PoolEntry {
+	fee := GetTransactionFee(&transaction, unspentTxOuts)
+	size := GetTransactionSize(&transaction)
+	entry := PoolEntry{
+		Transaction: transaction,
+		Fee:         fee,
+		Size:        size,
+		FeeRate:     GetFeeRate(fee, size),
+		Time:        CurrentUnixTimestamp(),
+		Height:      GetNextBlockHeight(),
+		parents:     make(map[string]*PoolEntry),
+		children:    make(map[string]*PoolEntry),
+	}
+	return &entry
+}
+
+// GetRequiredFee returns the fee a transaction of the given size pays at feeRate.
+func GetRequiredFee (feeRate int64, size int64) int64 {
+	return (feeRate*size + 999) / 1000
+}
 
 func GetTransactionById (id string) (bool, Transaction) {
-	for i, _ := range TransactionPool {
-		tx := TransactionPool[i]
-		if tx.Id == id {
-			return true, tx
-		}
+	entry := TransactionPool.Get(id)
+	if entry == nil {
+		return false, Transaction{}
 	}
-	return false, Transaction{}
+	return true, entry.Transaction
 }
 
-func AddToTransactionPool (transaction Transaction, unspentTxOuts []UnspentTxOut) error {
-	if ValidateTransaction(&transaction, unspentTxOuts) != true {
-		return errors.New("trying to add invalid tx to pool")
+var (
+	ErrTxInAlreadySpent = errors.New("txIn already spent")
+	ErrTxInMissing      = errors.New("txIn references a txOut that does not exist")
+)
+
+// AddToTransactionPool accepts a transaction into the pool and retries the orphans that were waiting
+// for it. Inputs are resolved against the node's own unspent txOuts and the pool, never against
+// txOuts supplied by the caller.
+func AddToTransactionPool (transaction Transaction) error {
+	err := acceptToTransactionPool(transaction)
+	if err != nil {
+		return err
 	}
+	ProcessOrphans(transaction.Id)
+	return nil
+}
 
-	if !IsValidTxForPool(transaction, TransactionPool) {
-		return errors.New("trying to add invalid tx to pool")
+func acceptToTransactionPool (transaction Transaction) error {
+	unspentTxOuts := GetUnspentTxOutsForPoolValidation()
+	missing, err := checkTransactionForPool(&transaction, unspentTxOuts)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		err := Orphans.Add(transaction, missing)
+		if err != nil {
+			return err
+		}
+		return ErrOrphanTransaction
 	}
 
-	TransactionPool = append(TransactionPool, transaction)
+	entry := NewPoolEntry(transaction, unspentTxOuts)
+	replaced, err := TransactionPool.Add(entry)
+	if err != nil {
+		return err
+	}
+	FeeEstimates.Track(entry)
+	if len(replaced) > 0 {
+		BroadcastReplacement(&transaction, replaced)
+	}
 	return nil
 }
 
-func UpdateTransactionPool () {
-	unspentTxOuts := GetAllUnspentTxOuts()
-	var newTransactionPool = []Transaction{}
-	for i := range TransactionPool {
-		tx := TransactionPool[i]
-		if !HasTxIn(tx, unspentTxOuts) {
-			newTransactionPool = append(newTransactionPool, tx)
+// checkTransactionForPool applies policy and consensus rules that do not depend on the pool's
+// contents. It returns the inputs it could not resolve, if they belong to a transaction the node
+// has never seen.
+func checkTransactionForPool (transaction *Transaction, unspentTxOuts []UnspentTxOut) ([]TxIn, error) {
+	err := CheckStandardTransaction(transaction)
+	if err != nil {
+		return nil, err
+	}
+	missing := GetMissingTxIns(transaction, unspentTxOuts)
+	if len(missing) > 0 {
+		for i := range missing {
+			err := CheckMissingTxIn(&missing[i])
+			if err != nil {
+				return nil, err
+			}
 		}
+		return missing, nil
 	}
-	TransactionPool = newTransactionPool
+	err = CheckTransaction(transaction, unspentTxOuts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction: %s", err.Error())
+	}
+	return nil, nil
 }
 
-func HasTxIn (transaction Transaction, unspentTxOuts []UnspentTxOut) bool {
-	for i := range unspentTxOuts {
-		txOut := unspentTxOuts[i]
-		if txOut.TxOutId == transaction.Id {
-			return true
+type TestAcceptResult struct {
+	TransactionId string   `json:"txid"`
+	Allowed       bool     `json:"allowed"`
+	Fee           int64    `json:"fee"`
+	Size          int64    `json:"size"`
+	FeeRate       int64    `json:"feeRate"`
+	Replaces      []string `json:"replaces,omitempty"`
+	RejectReason  string   `json:"rejectReason,omitempty"`
+}
+
+// TestAcceptTransaction runs every check AddToTransactionPool would run and reports the outcome
+// without adding the transaction to the pool, the orphans or the fee estimator, and without
+// broadcasting it.
+func TestAcceptTransaction (transaction Transaction) TestAcceptResult {
+	unspentTxOuts := GetUnspentTxOutsForPoolValidation()
+	entry := NewPoolEntry(transaction, unspentTxOuts)
+	result := TestAcceptResult{
+		TransactionId: GetTransactionId(&transaction),
+		Size:          entry.Size,
+	}
+	missing, err := checkTransactionForPool(&transaction, unspentTxOuts)
+	if err == nil && len(missing) > 0 {
+		outpoints := make([]string, len(missing))
+		for i := range missing {
+			outpoints[i] = GetOutpoint(missing[i].TxOutId, missing[i].TxOutIndex)
 		}
+		err = fmt.Errorf("transaction spends unknown outputs: %s", strings.Join(outpoints, ", "))
 	}
-	return false
+	if len(GetMissingTxIns(&transaction, unspentTxOuts)) == 0 {
+		result.Fee = entry.Fee
+		result.FeeRate = entry.FeeRate
+	}
+	if err != nil {
+		result.RejectReason = err.Error()
+		return result
+	}
+	replaces, err := TransactionPool.TestAccept(entry)
+	if err != nil {
+		result.RejectReason = err.Error()
+		return result
+	}
+	result.Allowed = true
+	result.Replaces = replaces
+	return result
 }
 
-func IsValidTxForPool(transaction Transaction, pool []Transaction) bool {
-	txPoolIns := GetTxPoolIns(pool)
-	for i := range transaction.TxIns {
-		txIn := transaction.TxIns[i]
-		if ContainsTxIn(txPoolIns, txIn) {
-			fmt.Printf("Transaction pool already contains txIn\n")
-			return false
+// AddPackageToTransactionPool accepts a child together with the unconfirmed parents it spends, which
+// need not pay the pool minimum on their own. Transactions already in the pool are skipped, and the
+// rest is admitted on the fee rate of the whole package.
+func AddPackageToTransactionPool (transactions []Transaction) error {
+	err := CheckPackage(transactions)
+	if err != nil {
+		return err
+	}
+	unspentTxOuts := GetUnspentTxOutsForPoolValidation()
+	entries := []*PoolEntry{}
+	for i := range transactions {
+		transaction := transactions[i]
+		if TransactionPool.Get(transaction.Id) != nil {
+			continue
 		}
+		missing, err := checkTransactionForPool(&transaction, unspentTxOuts)
+		if err != nil {
+			return fmt.Errorf("package transaction %s: %s", transaction.Id, err.Error())
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("package transaction %s spends unknown outputs", transaction.Id)
+		}
+		entries = append(entries, NewPoolEntry(transaction, unspentTxOuts))
+		unspentTxOuts = append(unspentTxOuts, GetUnspentTxOutsOfTransaction(&transaction)...)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	err = TransactionPool.AddPackage(entries)
+	if err != nil {
+		return err
+	}
+	// Package transactions are not tracked by the fee estimator: their own fee rates do not reflect
+	// what gets them mined.
+	for _, entry := range entries {
+		ProcessOrphans(entry.Transaction.Id)
+	}
+	return nil
+}
+
+// CheckPackage checks that transactions form a child and its parents: the child comes last, spends an
+// output of every other transaction, and no transaction spends one listed after it.
+func CheckPackage (transactions []Transaction) error {
+	if len(transactions) < 2 {
+		return errors.New("a package needs a child and at least one parent")
+	}
+	if len(transactions) > MaxPackageCount {
+		return fmt.Errorf("package has more than %d transactions", MaxPackageCount)
+	}
+	var size int64
+	txIns := []TxIn{}
+	positions := make(map[string]int)
+	for i := range transactions {
+		if _, exists := positions[transactions[i].Id]; exists {
+			return fmt.Errorf("transaction %s appears twice in the package", transactions[i].Id)
+		}
+		positions[transactions[i].Id] = i
+		size += GetTransactionSize(&transactions[i])
+		txIns = append(txIns, transactions[i].TxIns...)
+	}
+	if size > MaxPackageSize {
+		return fmt.Errorf("package is larger than %d bytes", MaxPackageSize)
+	}
+	if HasDuplicates(txIns) {
+		return errors.New("package transactions spend the same output twice")
+	}
+	for i := range transactions {
+		for j := range transactions[i].TxIns {
+			position, exists := positions[transactions[i].TxIns[j].TxOutId]
+			if exists && position >= i {
+				return fmt.Errorf("transaction %s is listed before its parent", transactions[i].Id)
+			}
+		}
+	}
+	child := &transactions[len(transactions)-1]
+	for i := range transactions[:len(transactions)-1] {
+		spent := false
+		for j := range child.TxIns {
+			if child.TxIns[j].TxOutId == transactions[i].Id {
+				spent = true
+				break
+			}
+		}
+		if !spent {
+			return fmt.Errorf("transaction %s is not a parent of the package child", transactions[i].Id)
+		}
+	}
+	return nil
+}
+
+type Replacement struct {
+	Transaction Transaction `json:"transaction"`
+	Replaced    []string    `json:"replaced"`
+}
+
+func BroadcastReplacement(transaction *Transaction, replaced []string) {
+	var message Message
+	message.Id = transaction.Id
+	message.Timestamp = CurrentUnixTimestamp()
+	message.MessageType = RESPONSE_TRANSACTION_REPLACED
+	out, err := json.Marshal(Replacement{Transaction: *transaction, Replaced: replaced})
+	if err != nil {
+		message.Message = err.Error()
+	}
+	message.Message = string(out)
+	broadcast <- message
+}
+
+// IsStandardTransaction applies the pool's relay policy on top of the consensus rules.
+func IsStandardTransaction (transaction *Transaction) bool {
+	err := CheckStandardTransaction(transaction)
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return false
 	}
 	return true
 }
 
+func CheckStandardTransaction (transaction *Transaction) error {
+	version := GetTransactionVersion(transaction)
+	if version > MaxStandardTransactionVersion {
+		return fmt.Errorf("non-standard transaction version: %d", version)
+	}
+	if !IsStandardDataCarrier(transaction) {
+		return errors.New("non-standard data carrier outputs")
+	}
+	for i := range transaction.TxOuts {
+		if !isPublicKeyTxOut(&transaction.TxOuts[i]) || IsPublicKeyHashAddress(transaction.TxOuts[i].Address) {
+			continue
+		}
+		_, err := GetPublicECDSAKeyFromCompressedAddress(transaction.TxOuts[i].Address)
+		if err != nil {
+			return fmt.Errorf("txOut %d pays to an invalid address: %s", i, err.Error())
+		}
+	}
+	return nil
+}
+
+// CheckMissingTxIn explains why an input could not be resolved. It returns nil when the referenced
+// transaction is unknown, in which case the spending transaction is an orphan.
+func CheckMissingTxIn (txIn *TxIn) error {
+	found, parent := FindTransaction(txIn.TxOutId)
+	if !found {
+		return nil
+	}
+	outpoint := GetOutpoint(txIn.TxOutId, txIn.TxOutIndex)
+	if txIn.TxOutIndex < 0 || txIn.TxOutIndex >= int64(len(parent.TxOuts)) || IsDataTxOut(&parent.TxOuts[txIn.TxOutIndex]) {
+		return fmt.Errorf("%w: %s", ErrTxInMissing, outpoint)
+	}
+	return fmt.Errorf("%w: %s", ErrTxInAlreadySpent, outpoint)
+}
+
+// GetUnspentTxOutsWithPool returns the confirmed unspent txOuts combined with the outputs of pending
+// transactions, leaving out everything already spent by the pool. Before OutputIndexHeight the
+// pending outputs are left out as well, since the pool does not accept spends of them.
+func GetUnspentTxOutsWithPool () []UnspentTxOut {
+	transactions := TransactionPool.GetTransactions()
+	unspentTxOuts := UpdateUnspentTxOuts(transactions, GetAllUnspentTxOuts())
+	if GetNextBlockHeight() >= OutputIndexHeight {
+		return unspentTxOuts
+	}
+	pending := make(map[string]bool)
+	for _, tx := range transactions {
+		pending[tx.Id] = true
+	}
+	var confirmed = []UnspentTxOut{}
+	for _, txOut := range unspentTxOuts {
+		if !pending[txOut.TxOutId] {
+			confirmed = append(confirmed, txOut)
+		}
+	}
+	return confirmed
+}
+
+// GetUnspentTxOutsForPoolValidation returns the txOuts a new pool transaction may spend: the confirmed
+// unspent txOuts and every output of a pending transaction. Outputs already spent in the pool are
+// kept so that conflicting spends reach the replacement rules. Before OutputIndexHeight pending
+// outputs are left out: a block at that height would index them differently.
+func GetUnspentTxOutsForPoolValidation () []UnspentTxOut {
+	unspentTxOuts := GetAllUnspentTxOuts()
+	if GetNextBlockHeight() < OutputIndexHeight {
+		return unspentTxOuts
+	}
+	for _, tx := range TransactionPool.GetTransactions() {
+		unspentTxOuts = append(unspentTxOuts, GetUnspentTxOutsOfTransaction(&tx)...)
+	}
+	return unspentTxOuts
+}
+
+// UpdateTransactionPool drops the transactions confirmed by the latest block, then every transaction
+// that no longer has its inputs available together with the transactions depending on it.
+func UpdateTransactionPool () {
+	block := GetLatestBlock()
+	for i := range block.Data {
+		TransactionPool.Remove(block.Data[i].Id)
+	}
+	unspentTxOuts := GetUnspentTxOutsForPoolValidation()
+	for _, tx := range TransactionPool.GetTransactions() {
+		if len(GetMissingTxIns(&tx, unspentTxOuts)) > 0 {
+			TransactionPool.RemoveWithDescendants(tx.Id)
+		}
+	}
+	TransactionPool.Expire()
+}
+
 func ContainsTxIn (txPoolIns []TxIn, txIn TxIn) bool {
 	for i := range txPoolIns {
 		txPoolIn := txPoolIns[i]
@@ -74,14 +451,460 @@ func ContainsTxIn (txPoolIns []TxIn, txIn TxIn) bool {
 	return false
 }
 
-func GetTxPoolIns (pool []Transaction) []TxIn {
-	var txIns []TxIn
-	for i := range pool {
-		transaction := pool[i]
-		for j := range transaction.TxIns {
-			txIn := transaction.TxIns[j]
-			txIns = append(txIns, txIn)
+// Add inserts an entry that pays at least the current minimum fee rate, then trims the pool
+// back to its size limit. An entry spending the same inputs as pool transactions replaces them under
+// the replacement rules; the ids of the evicted transactions are returned. Conflicts are looked up
+// under the same lock as the insert, so a concurrent spend of the same inputs cannot slip in between.
+func (pool *TxPool) Add(entry *PoolEntry) ([]string, error) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.expire()
+	conflicts := pool.getConflicts(&entry.Transaction)
+	if len(conflicts) > 0 {
+		return pool.replace(entry, conflicts)
+	}
+	err := pool.checkAdd(entry)
+	if err != nil {
+		return nil, err
+	}
+	pool.insert(entry)
+	pool.trim()
+	if _, exists := pool.entries[entry.Transaction.Id]; !exists {
+		return nil, errors.New("transaction pool is full")
+	}
+	return nil, nil
+}
+
+// getConflicts returns the ids of the pool transactions spending any of the inputs of transaction.
+func (pool *TxPool) getConflicts(transaction *Transaction) []string {
+	conflicts := []string{}
+	for id, entry := range pool.entries {
+		for i := range transaction.TxIns {
+			if ContainsTxIn(entry.Transaction.TxIns, transaction.TxIns[i]) {
+				conflicts = append(conflicts, id)
+				break
+			}
+		}
+	}
+	return conflicts
+}
+
+// replace evicts the conflicting transactions and their descendants in favour of entry. The
+// replacement must pay a strictly higher fee rate than every transaction it conflicts with and
+// a higher absolute fee than everything it evicts, by at least the incremental relay fee for its own size.
+func (pool *TxPool) replace(entry *PoolEntry, conflicts []string) ([]string, error) {
+	evicted, err := pool.checkReplace(entry, conflicts)
+	if err != nil {
+		return nil, err
+	}
+	replaced := []string{}
+	for id := range evicted {
+		pool.remove(id)
+		replaced = append(replaced, id)
+	}
+	pool.insert(entry)
+	pool.trim()
+	return replaced, nil
+}
+
+// TestAccept reports whether entry would be accepted, replacing the pool transactions it conflicts
+// with if there are any, and returns the ids it would evict. The pool is left untouched.
+func (pool *TxPool) TestAccept(entry *PoolEntry) ([]string, error) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	conflicts := pool.getConflicts(&entry.Transaction)
+	if len(conflicts) == 0 {
+		return nil, pool.checkAdd(entry)
+	}
+	evicted, err := pool.checkReplace(entry, conflicts)
+	if err != nil {
+		return nil, err
+	}
+	replaced := []string{}
+	for id := range evicted {
+		replaced = append(replaced, id)
+	}
+	return replaced, nil
+}
+
+// AddPackage adds related entries, parents first, as a unit. The package is admitted on its combined
+// fee rate, so a child can pay for a parent below the pool minimum.
+func (pool *TxPool) AddPackage(entries []*PoolEntry) error {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.expire()
+	var size, fees int64
+	for _, entry := range entries {
+		if _, exists := pool.entries[entry.Transaction.Id]; exists {
+			return fmt.Errorf("transaction %s already in pool", entry.Transaction.Id)
+		}
+		if len(pool.getConflicts(&entry.Transaction)) > 0 {
+			return fmt.Errorf("package transaction %s conflicts with the pool", entry.Transaction.Id)
+		}
+		size += entry.Size
+		fees += entry.Fee
+	}
+	feeRate := GetFeeRate(fees, size)
+	minFeeRate := pool.minFeeRate()
+	if feeRate < minFeeRate {
+		return fmt.Errorf("package fee rate %d is below the minimum of %d", feeRate, minFeeRate)
+	}
+	for i, entry := range entries {
+		err := pool.checkPackageLimits(entry, nil)
+		if err != nil {
+			for _, inserted := range entries[:i] {
+				pool.remove(inserted.Transaction.Id)
+			}
+			return err
+		}
+		pool.insert(entry)
+	}
+	pool.trim()
+	for _, entry := range entries {
+		if _, exists := pool.entries[entry.Transaction.Id]; !exists {
+			for _, other := range entries {
+				pool.removeWithDescendants(other.Transaction.Id)
+			}
+			return errors.New("transaction pool is full")
 		}
 	}
-	return txIns
-}
\ No newline at end of file
+	return nil
+}
+
+func (pool *TxPool) Get(id string) *PoolEntry {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return pool.entries[id]
+}
+
+func (pool *TxPool) Remove(id string) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.remove(id)
+}
+
+// RemoveWithDescendants removes a transaction and every pool transaction spending its outputs.
+func (pool *TxPool) RemoveWithDescendants(id string) []string {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return pool.removeWithDescendants(id)
+}
+
+func (pool *TxPool) Expire() {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.expire()
+}
+
+// GetEntries returns the pool entries from the highest to the lowest fee rate, with their ancestor
+// and descendant totals filled in.
+func (pool *TxPool) GetEntries() []PoolEntry {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	entries := make([]PoolEntry, 0, len(pool.byFeeRate))
+	for i := len(pool.byFeeRate) - 1; i >= 0; i-- {
+		entry := *pool.byFeeRate[i]
+		entry.AncestorCount, entry.AncestorSize, entry.AncestorFees = packageTotals(&entry, pool.ancestors(pool.byFeeRate[i], nil))
+		entry.DescendantCount, entry.DescendantSize, entry.DescendantFees = packageTotals(&entry, pool.descendants(pool.byFeeRate[i]))
+		entry.parents = nil
+		entry.children = nil
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (pool *TxPool) GetTransactions() []Transaction {
+	entries := pool.GetEntries()
+	transactions := make([]Transaction, 0, len(entries))
+	for i := range entries {
+		transactions = append(transactions, entries[i].Transaction)
+	}
+	return transactions
+}
+
+func (pool *TxPool) Size() int64 {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return pool.size
+}
+
+func (pool *TxPool) GetMinFeeRate() int64 {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return pool.minFeeRate()
+}
+
+func (pool *TxPool) checkAdd(entry *PoolEntry) error {
+	if _, exists := pool.entries[entry.Transaction.Id]; exists {
+		return errors.New("transaction already in pool")
+	}
+	minFeeRate := pool.minFeeRate()
+	if entry.FeeRate < minFeeRate {
+		return fmt.Errorf("fee rate %d is below the minimum of %d", entry.FeeRate, minFeeRate)
+	}
+	return pool.checkPackageLimits(entry, nil)
+}
+
+// checkReplace applies the replacement rules and returns the conflicts and their descendants that
+// entry would evict.
+func (pool *TxPool) checkReplace(entry *PoolEntry, conflicts []string) (map[string]*PoolEntry, error) {
+	if _, exists := pool.entries[entry.Transaction.Id]; exists {
+		return nil, errors.New("transaction already in pool")
+	}
+	evicted := make(map[string]*PoolEntry)
+	for _, id := range conflicts {
+		conflict, exists := pool.entries[id]
+		if !exists {
+			continue
+		}
+		if entry.FeeRate <= conflict.FeeRate {
+			return nil, fmt.Errorf("replacement fee rate %d does not exceed %d of %s", entry.FeeRate, conflict.FeeRate, id)
+		}
+		evicted[id] = conflict
+		for _, descendant := range pool.descendants(conflict) {
+			evicted[descendant.Transaction.Id] = descendant
+		}
+	}
+	var evictedFees int64
+	for _, evictedEntry := range evicted {
+		evictedFees += evictedEntry.Fee
+	}
+	if entry.Fee <= evictedFees {
+		return nil, fmt.Errorf("replacement fee %d does not exceed the %d paid by replaced transactions", entry.Fee, evictedFees)
+	}
+	if entry.Fee-evictedFees < GetRequiredFee(IncrementalRelayFeeRate, entry.Size) {
+		return nil, fmt.Errorf("replacement does not pay for its own relay at %d per 1000 bytes", IncrementalRelayFeeRate)
+	}
+	minFeeRate := pool.minFeeRate()
+	if entry.FeeRate < minFeeRate {
+		return nil, fmt.Errorf("fee rate %d is below the minimum of %d", entry.FeeRate, minFeeRate)
+	}
+	excluded := make(map[string]bool)
+	for id := range evicted {
+		excluded[id] = true
+	}
+	for i := range entry.Transaction.TxIns {
+		if excluded[entry.Transaction.TxIns[i].TxOutId] {
+			return nil, errors.New("replacement spends an output of a transaction it replaces")
+		}
+	}
+	err := pool.checkPackageLimits(entry, excluded)
+	if err != nil {
+		return nil, err
+	}
+	return evicted, nil
+}
+
+func (pool *TxPool) insert(entry *PoolEntry) {
+	index := sort.Search(len(pool.byFeeRate), func(i int) bool {
+		return lowerPriority(entry, pool.byFeeRate[i])
+	})
+	pool.byFeeRate = append(pool.byFeeRate, nil)
+	copy(pool.byFeeRate[index+1:], pool.byFeeRate[index:])
+	pool.byFeeRate[index] = entry
+	pool.entries[entry.Transaction.Id] = entry
+	pool.size += entry.Size
+	for _, parent := range pool.parentsOf(&entry.Transaction, nil) {
+		entry.parents[parent.Transaction.Id] = parent
+		parent.children[entry.Transaction.Id] = entry
+	}
+	for id, other := range pool.entries {
+		for i := range other.Transaction.TxIns {
+			if other.Transaction.TxIns[i].TxOutId == entry.Transaction.Id {
+				entry.children[id] = other
+				other.parents[entry.Transaction.Id] = entry
+				break
+			}
+		}
+	}
+}
+
+func (pool *TxPool) remove(id string) *PoolEntry {
+	entry, exists := pool.entries[id]
+	if !exists {
+		return nil
+	}
+	for i := range pool.byFeeRate {
+		if pool.byFeeRate[i] == entry {
+			pool.byFeeRate = append(pool.byFeeRate[:i], pool.byFeeRate[i+1:]...)
+			break
+		}
+	}
+	for parentId, parent := range entry.parents {
+		delete(parent.children, id)
+		delete(entry.parents, parentId)
+	}
+	for childId, child := range entry.children {
+		delete(child.parents, id)
+		delete(entry.children, childId)
+	}
+	delete(pool.entries, id)
+	pool.size -= entry.Size
+	return entry
+}
+
+func (pool *TxPool) removeWithDescendants(id string) []string {
+	entry, exists := pool.entries[id]
+	if !exists {
+		return nil
+	}
+	removed := []string{}
+	for _, descendant := range pool.descendants(entry) {
+		pool.remove(descendant.Transaction.Id)
+		removed = append(removed, descendant.Transaction.Id)
+	}
+	pool.remove(id)
+	return append(removed, id)
+}
+
+// checkPackageLimits rejects an entry that would exceed the ancestor limits itself or push one of its
+// ancestors over the descendant limits. Entries in excluded are about to be removed and do not count.
+func (pool *TxPool) checkPackageLimits(entry *PoolEntry, excluded map[string]bool) error {
+	ancestors := pool.ancestors(entry, excluded)
+	ancestorCount, ancestorSize, _ := packageTotals(entry, ancestors)
+	if ancestorCount > MaxAncestorCount {
+		return fmt.Errorf("too many unconfirmed ancestors: %d > %d", ancestorCount, MaxAncestorCount)
+	}
+	if ancestorSize > MaxAncestorSize {
+		return fmt.Errorf("unconfirmed ancestors too large: %d > %d bytes", ancestorSize, MaxAncestorSize)
+	}
+	for _, ancestor := range ancestors {
+		descendants := []*PoolEntry{}
+		for _, descendant := range pool.descendants(ancestor) {
+			if !excluded[descendant.Transaction.Id] {
+				descendants = append(descendants, descendant)
+			}
+		}
+		descendantCount, descendantSize, _ := packageTotals(ancestor, append(descendants, entry))
+		if descendantCount > MaxDescendantCount {
+			return fmt.Errorf("too many unconfirmed descendants of %s: %d > %d", ancestor.Transaction.Id, descendantCount, MaxDescendantCount)
+		}
+		if descendantSize > MaxDescendantSize {
+			return fmt.Errorf("unconfirmed descendants of %s too large: %d > %d bytes", ancestor.Transaction.Id, descendantSize, MaxDescendantSize)
+		}
+	}
+	return nil
+}
+
+// parentsOf returns the pool entries whose outputs transaction spends.
+func (pool *TxPool) parentsOf(transaction *Transaction, excluded map[string]bool) []*PoolEntry {
+	parents := []*PoolEntry{}
+	seen := make(map[string]bool)
+	for i := range transaction.TxIns {
+		id := transaction.TxIns[i].TxOutId
+		parent, exists := pool.entries[id]
+		if exists && !seen[id] && !excluded[id] {
+			seen[id] = true
+			parents = append(parents, parent)
+		}
+	}
+	return parents
+}
+
+// ancestors returns every pool entry the given entry depends on, directly or not.
+func (pool *TxPool) ancestors(entry *PoolEntry, excluded map[string]bool) []*PoolEntry {
+	found := make(map[string]bool)
+	result := []*PoolEntry{}
+	queue := pool.parentsOf(&entry.Transaction, excluded)
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		if found[parent.Transaction.Id] {
+			continue
+		}
+		found[parent.Transaction.Id] = true
+		result = append(result, parent)
+		queue = append(queue, pool.parentsOf(&parent.Transaction, excluded)...)
+	}
+	return result
+}
+
+// trim evicts entries until the pool fits, walking byFeeRate from the lowest fee rate up. Entries
+// with children in the pool are passed over, so a low fee parent is kept while a child pays for it and
+// only goes once its children have been evicted. The rolling minimum fee rate is raised above the
+// rate of every evicted entry.
+func (pool *TxPool) trim() {
+	for pool.size > pool.MaxSize {
+		var evicted *PoolEntry
+		for _, entry := range pool.byFeeRate {
+			if len(entry.children) == 0 {
+				evicted = entry
+				break
+			}
+		}
+		if evicted == nil {
+			return
+		}
+		pool.remove(evicted.Transaction.Id)
+		feeRate := float64(evicted.FeeRate + IncrementalRelayFeeRate)
+		if feeRate > pool.rollingMinFeeRate {
+			pool.rollingMinFeeRate = feeRate
+		}
+		pool.lastRollingFeeRateTime = CurrentUnixTimestamp()
+	}
+}
+
+// descendants returns every pool entry that spends an output of the given entry, directly or not.
+func (pool *TxPool) descendants(entry *PoolEntry) []*PoolEntry {
+	found := make(map[string]bool)
+	result := []*PoolEntry{}
+	queue := []*PoolEntry{entry}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		for childId, child := range parent.children {
+			if found[childId] {
+				continue
+			}
+			found[childId] = true
+			result = append(result, child)
+			queue = append(queue, child)
+		}
+	}
+	return result
+}
+
+func (pool *TxPool) expire() {
+	cutoff := CurrentUnixTimestamp() - pool.Expiry
+	for id, entry := range pool.entries {
+		if entry.Time < cutoff {
+			pool.removeWithDescendants(id)
+		}
+	}
+}
+
+func (pool *TxPool) minFeeRate() int64 {
+	if pool.rollingMinFeeRate > 0 {
+		now := CurrentUnixTimestamp()
+		elapsed := float64(now - pool.lastRollingFeeRateTime)
+		pool.rollingMinFeeRate /= math.Pow(2, elapsed/RollingFeeRateHalfLife)
+		pool.lastRollingFeeRateTime = now
+		if pool.rollingMinFeeRate < float64(IncrementalRelayFeeRate)/2 {
+			pool.rollingMinFeeRate = 0
+		}
+	}
+	rolling := int64(math.Ceil(pool.rollingMinFeeRate))
+	if rolling > pool.MinRelayFeeRate {
+		return rolling
+	}
+	return pool.MinRelayFeeRate
+}
+
+// packageTotals returns the count, size and fees of entry together with the given related entries.
+func packageTotals(entry *PoolEntry, related []*PoolEntry) (int64, int64, int64) {
+	count, size, fees := int64(1), entry.Size, entry.Fee
+	for _, other := range related {
+		count++
+		size += other.Size
+		fees += other.Fee
+	}
+	return count, size, fees
+}
+
+// lowerPriority orders entries by fee rate, newer entries first among equal rates so they are evicted first.
+func lowerPriority(a *PoolEntry, b *PoolEntry) bool {
+	if a.FeeRate != b.FeeRate {
+		return a.FeeRate < b.FeeRate
+	}
+	return a.Time >= b.Time
+}
@@ -0,0 +1,75 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Requests that use the wallet keys or the watch-only wallets must carry the wallet token as a bearer
+// token. The token is read from WalletTokenFile, or generated there with owner-only permissions on
+// first start, so only users who can read the node's files can spend its coins or see what it
+// watches. A web page cannot set the Authorization header on a cross-site request without a
+// preflight, and requiring a JSON content type rules out the simple form and text/plain posts that
+// skip it.
+var WalletTokenFile = "wallet.token"
+
+var walletToken string
+
+// LoadWalletToken reads the wallet token from path, creating a random one if the file does not exist.
+func LoadWalletToken(path string) error {
+	out, err := os.ReadFile(path)
+	if err == nil {
+		token := strings.TrimSpace(string(out))
+		if token == "" {
+			return errors.New("wallet token file is empty")
+		}
+		walletToken = token
+		return nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	random := make([]byte, 32)
+	_, err = rand.Read(random)
+	if err != nil {
+		return err
+	}
+	token := hex.EncodeToString(random)
+	temporary := path + ".tmp"
+	err = os.WriteFile(temporary, []byte(token+"\n"), 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(temporary, path)
+	if err != nil {
+		return err
+	}
+	walletToken = token
+	return nil
+}
+
+// RequireWalletAuth rejects requests without the wallet token, and requests with a body that is not
+// JSON.
+func RequireWalletAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if walletToken == "" || !found || subtle.ConstantTimeCompare([]byte(token), []byte(walletToken)) != 1 {
+			http.Error(w, "wallet token required", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != "application/json" {
+				http.Error(w, "content type must be application/json", http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,508 @@
+package crypto
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Watch-only wallets follow addresses and extended public keys without holding private keys. Only
+// what the user imported is persisted; the transactions touching a wallet are found again by scanning
+// the chain on start and as blocks connect.
+const DefaultGapLimit = 20
+
+var WalletsFile = "wallets.json"
+
+type WatchedAddress struct {
+	Address string `json:"address"`
+	Label   string `json:"label,omitempty"`
+	Path    string `json:"path,omitempty"`
+
+	extendedKey int
+}
+
+// WatchedExtendedKey is an account level extended public key. Addresses are derived on its receive
+// and change chains up to DefaultGapLimit beyond the last one used.
+type WatchedExtendedKey struct {
+	ExtendedKey  string `json:"extendedKey"`
+	Label        string `json:"label,omitempty"`
+	ReceiveCount uint32 `json:"receiveCount"`
+	ChangeCount  uint32 `json:"changeCount"`
+}
+
+// WalletTransaction is a transaction paying to or spending from a wallet. Amount is what it
+// received less what it sent; BlockIndex is -1 for transactions still in the pool.
+type WalletTransaction struct {
+	TransactionId string `json:"txid"`
+	BlockIndex    int64  `json:"blockIndex"`
+	Confirmations int64  `json:"confirmations"`
+	Received      int64  `json:"received"`
+	Sent          int64  `json:"sent"`
+	Amount        int64  `json:"amount"`
+}
+
+type WatchOnlyWallet struct {
+	Name         string               `json:"name"`
+	Addresses    []WatchedAddress     `json:"addresses"`
+	ExtendedKeys []WatchedExtendedKey `json:"extendedKeys"`
+
+	derived   []WatchedAddress
+	outpoints map[string]int64
+	history   []WalletTransaction
+	// addresses replaces the imported and derived addresses of the wallet following the keystore.
+	addresses func() []WatchedAddress
+}
+
+type WalletInfo struct {
+	Name          string               `json:"name"`
+	Balance       int64                `json:"balance"`
+	Addresses     []WatchedAddress     `json:"addresses"`
+	ExtendedKeys  []WatchedExtendedKey `json:"extendedKeys"`
+	UnspentTxOuts []UnspentTxOut       `json:"unspentTxOuts"`
+	History       []WalletTransaction  `json:"history"`
+}
+
+type WalletManager struct {
+	Wallets map[string]*WatchOnlyWallet `json:"wallets"`
+
+	mutex    sync.Mutex
+	keystore *WatchOnlyWallet
+}
+
+var WatchOnlyWallets = NewWalletManager()
+
+func NewWalletManager() *WalletManager {
+	manager := WalletManager{
+		Wallets:  make(map[string]*WatchOnlyWallet),
+		keystore: &WatchOnlyWallet{Name: "keystore", addresses: getKeystoreAddresses},
+	}
+	return &manager
+}
+
+// LoadWatchOnlyWallets reads the wallets saved by a previous run and scans the chain for them and
+// for the keystore.
+func LoadWatchOnlyWallets(path string) error {
+	manager := NewWalletManager()
+	out, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		err = json.Unmarshal(out, manager)
+		if err != nil {
+			return err
+		}
+	}
+	for _, wallet := range manager.Wallets {
+		manager.scan(wallet, 0)
+	}
+	manager.scan(manager.keystore, 0)
+	WatchOnlyWallets = manager
+	return nil
+}
+
+func (manager *WalletManager) Create(name string) error {
+	if name == "" {
+		return errors.New("wallet name must not be empty")
+	}
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if _, exists := manager.Wallets[name]; exists {
+		return fmt.Errorf("wallet %s already exists", name)
+	}
+	wallet := &WatchOnlyWallet{
+		Name:         name,
+		Addresses:    []WatchedAddress{},
+		ExtendedKeys: []WatchedExtendedKey{},
+	}
+	manager.Wallets[name] = wallet
+	manager.scan(wallet, 0)
+	return manager.save()
+}
+
+// ImportAddress adds an address to a wallet, or changes its label if it is already watched.
+func (manager *WalletManager) ImportAddress(name string, address string, label string) error {
+	address, err := DecodeAddress(address)
+	if err != nil {
+		return err
+	}
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	wallet, exists := manager.Wallets[name]
+	if !exists {
+		return fmt.Errorf("wallet %s not found", name)
+	}
+	for i := range wallet.Addresses {
+		if wallet.Addresses[i].Address == address {
+			wallet.Addresses[i].Label = label
+			return manager.save()
+		}
+	}
+	wallet.Addresses = append(wallet.Addresses, WatchedAddress{Address: address, Label: label})
+	manager.scan(wallet, 0)
+	return manager.save()
+}
+
+func (manager *WalletManager) ImportExtendedKey(name string, extendedKey string, label string) error {
+	key, err := ParseExtendedKey(extendedKey)
+	if err != nil {
+		return err
+	}
+	if key.Private {
+		return errors.New("watch-only wallets take extended public keys")
+	}
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	wallet, exists := manager.Wallets[name]
+	if !exists {
+		return fmt.Errorf("wallet %s not found", name)
+	}
+	for i := range wallet.ExtendedKeys {
+		if wallet.ExtendedKeys[i].ExtendedKey == extendedKey {
+			wallet.ExtendedKeys[i].Label = label
+			wallet.derived = nil
+			return manager.save()
+		}
+	}
+	wallet.ExtendedKeys = append(wallet.ExtendedKeys, WatchedExtendedKey{ExtendedKey: extendedKey, Label: label})
+	wallet.derived = nil
+	manager.scan(wallet, 0)
+	return manager.save()
+}
+
+func (manager *WalletManager) GetWallet(name string) (*WalletInfo, error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	wallet, exists := manager.Wallets[name]
+	if !exists {
+		return nil, fmt.Errorf("wallet %s not found", name)
+	}
+	return manager.info(wallet), nil
+}
+
+// GetKeystoreWallet returns the balance, txOuts and history of the keys in the keystore.
+func (manager *WalletManager) GetKeystoreWallet() *WalletInfo {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	return manager.info(manager.keystore)
+}
+
+// Rescan rebuilds the history of a wallet from the block at height fromIndex on, picking up
+// transactions of addresses imported after their blocks connected. An empty name rescans the
+// keystore.
+func (manager *WalletManager) Rescan(name string, fromIndex int64) (*WalletInfo, error) {
+	if fromIndex < 0 || fromIndex > GetLatestBlock().Index {
+		return nil, fmt.Errorf("height must be between 0 and %d", GetLatestBlock().Index)
+	}
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	wallet := manager.keystore
+	if name != "" {
+		var exists bool
+		wallet, exists = manager.Wallets[name]
+		if !exists {
+			return nil, fmt.Errorf("wallet %s not found", name)
+		}
+	}
+	manager.scan(wallet, fromIndex)
+	if wallet != manager.keystore {
+		err := manager.save()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return manager.info(wallet), nil
+}
+
+// GetAddressHistory scans the chain and the pool for the transactions of a single address.
+func GetAddressHistory(address string) []WalletTransaction {
+	wallet := &WatchOnlyWallet{Addresses: []WatchedAddress{{Address: NormalizeAddress(address)}}}
+	manager := NewWalletManager()
+	manager.scan(wallet, 0)
+	return manager.info(wallet).History
+}
+
+func (manager *WalletManager) GetWallets() []WalletInfo {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	wallets := []WalletInfo{}
+	for _, wallet := range manager.Wallets {
+		wallets = append(wallets, *manager.info(wallet))
+	}
+	sort.Slice(wallets, func(i, j int) bool {
+		return wallets[i].Name < wallets[j].Name
+	})
+	return wallets
+}
+
+// GetLabel returns the label an address carries in any wallet.
+func (manager *WalletManager) GetLabel(address string) string {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	for _, wallet := range manager.Wallets {
+		for _, watched := range wallet.watched() {
+			if watched.Address == address && watched.Label != "" {
+				return watched.Label
+			}
+		}
+	}
+	return ""
+}
+
+// ProcessBlock records the transactions of a newly connected block in the wallets they touch.
+func (manager *WalletManager) ProcessBlock(block *Block) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	changed := false
+	for _, wallet := range manager.Wallets {
+		if manager.processBlock(wallet, block) {
+			changed = true
+		}
+	}
+	manager.processBlock(manager.keystore, block)
+	if changed {
+		err := manager.save()
+		if err != nil {
+			fmt.Printf("Wallets could not be saved: %s\n", err.Error())
+		}
+	}
+}
+
+// scan rebuilds the history and outputs of wallet from the block at height fromIndex on.
+func (manager *WalletManager) scan(wallet *WatchOnlyWallet, fromIndex int64) {
+	wallet.outpoints = make(map[string]int64)
+	history := []WalletTransaction{}
+	for i := range wallet.history {
+		if wallet.history[i].BlockIndex < fromIndex {
+			history = append(history, wallet.history[i])
+		}
+	}
+	wallet.history = history
+	blockChain := GetBlockChain()
+	for i := range blockChain {
+		if blockChain[i].Index < fromIndex {
+			manager.collectOutpoints(wallet, blockChain[i])
+			continue
+		}
+		manager.processBlock(wallet, blockChain[i])
+	}
+}
+
+// collectOutpoints follows the outputs of wallet in a block without recording history.
+func (manager *WalletManager) collectOutpoints(wallet *WatchOnlyWallet, block *Block) {
+	addresses := wallet.addressSet()
+	for i := range block.Data {
+		transaction := &block.Data[i]
+		for j := range transaction.TxIns {
+			delete(wallet.outpoints, GetOutpoint(transaction.TxIns[j].TxOutId, transaction.TxIns[j].TxOutIndex))
+		}
+		for j := range transaction.TxOuts {
+			if _, exists := addresses[transaction.TxOuts[j].Address]; exists {
+				wallet.outpoints[GetOutpoint(transaction.Id, int64(j))] = transaction.TxOuts[j].Amount
+			}
+		}
+	}
+}
+
+// processBlock records the transactions of block that pay to or spend from wallet and reports
+// whether the derived address range of an extended key grew.
+func (manager *WalletManager) processBlock(wallet *WatchOnlyWallet, block *Block) bool {
+	if wallet.outpoints == nil {
+		wallet.outpoints = make(map[string]int64)
+	}
+	extended := false
+	addresses := wallet.addressSet()
+	for i := range block.Data {
+		transaction := &block.Data[i]
+		touched := false
+		walletTransaction := WalletTransaction{TransactionId: transaction.Id, BlockIndex: block.Index}
+		for j := range transaction.TxIns {
+			outpoint := GetOutpoint(transaction.TxIns[j].TxOutId, transaction.TxIns[j].TxOutIndex)
+			if amount, exists := wallet.outpoints[outpoint]; exists {
+				delete(wallet.outpoints, outpoint)
+				walletTransaction.Sent += amount
+				touched = true
+			}
+		}
+		for j := range transaction.TxOuts {
+			watched, exists := addresses[transaction.TxOuts[j].Address]
+			if !exists {
+				continue
+			}
+			wallet.outpoints[GetOutpoint(transaction.Id, int64(j))] = transaction.TxOuts[j].Amount
+			walletTransaction.Received += transaction.TxOuts[j].Amount
+			touched = true
+			if wallet.markUsed(watched) {
+				extended = true
+				addresses = wallet.addressSet()
+			}
+		}
+		if touched {
+			walletTransaction.Amount = walletTransaction.Received - walletTransaction.Sent
+			wallet.history = append(wallet.history, walletTransaction)
+		}
+	}
+	return extended
+}
+
+func (manager *WalletManager) info(wallet *WatchOnlyWallet) *WalletInfo {
+	unspentTxOuts := []UnspentTxOut{}
+	var balance int64
+	addresses := wallet.addressSet()
+	allUnspentTxOuts := GetAllUnspentTxOuts()
+	for i := range allUnspentTxOuts {
+		if _, exists := addresses[allUnspentTxOuts[i].Address]; exists {
+			unspentTxOuts = append(unspentTxOuts, allUnspentTxOuts[i])
+			balance += allUnspentTxOuts[i].Amount
+		}
+	}
+	history := []WalletTransaction{}
+	latestIndex := GetLatestBlock().Index
+	for _, walletTransaction := range wallet.history {
+		walletTransaction.Confirmations = latestIndex - walletTransaction.BlockIndex + 1
+		history = append(history, walletTransaction)
+	}
+	info := WalletInfo{
+		Name:          wallet.Name,
+		Balance:       balance,
+		Addresses:     wallet.watched(),
+		ExtendedKeys:  append([]WatchedExtendedKey{}, wallet.ExtendedKeys...),
+		UnspentTxOuts: unspentTxOuts,
+		History:       append(history, manager.pending(wallet, addresses)...),
+	}
+	return &info
+}
+
+// pending lists the pool transactions paying to or spending from wallet without confirmations.
+func (manager *WalletManager) pending(wallet *WatchOnlyWallet, addresses map[string]WatchedAddress) []WalletTransaction {
+	poolTransactions := TransactionPool.GetTransactions()
+	byId := make(map[string]*Transaction)
+	for i := range poolTransactions {
+		byId[poolTransactions[i].Id] = &poolTransactions[i]
+	}
+	pending := []WalletTransaction{}
+	for i := range poolTransactions {
+		transaction := &poolTransactions[i]
+		walletTransaction := WalletTransaction{TransactionId: transaction.Id, BlockIndex: -1}
+		for _, txIn := range transaction.TxIns {
+			if amount, exists := wallet.outpoints[GetOutpoint(txIn.TxOutId, txIn.TxOutIndex)]; exists {
+				walletTransaction.Sent += amount
+				continue
+			}
+			parent, exists := byId[txIn.TxOutId]
+			if !exists || txIn.TxOutIndex < 0 || txIn.TxOutIndex >= int64(len(parent.TxOuts)) {
+				continue
+			}
+			if _, exists := addresses[parent.TxOuts[txIn.TxOutIndex].Address]; exists {
+				walletTransaction.Sent += parent.TxOuts[txIn.TxOutIndex].Amount
+			}
+		}
+		for _, txOut := range transaction.TxOuts {
+			if _, exists := addresses[txOut.Address]; exists {
+				walletTransaction.Received += txOut.Amount
+			}
+		}
+		if walletTransaction.Sent == 0 && walletTransaction.Received == 0 {
+			continue
+		}
+		walletTransaction.Amount = walletTransaction.Received - walletTransaction.Sent
+		pending = append(pending, walletTransaction)
+	}
+	return pending
+}
+
+func (manager *WalletManager) save() error {
+	out, err := json.MarshalIndent(manager, "", "  ")
+	if err != nil {
+		return err
+	}
+	temporary := WalletsFile + ".tmp"
+	err = os.WriteFile(temporary, out, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporary, WalletsFile)
+}
+
+// watched returns the imported addresses followed by those derived from extended keys.
+func (wallet *WatchOnlyWallet) watched() []WatchedAddress {
+	if wallet.addresses != nil {
+		return wallet.addresses()
+	}
+	if wallet.derived == nil {
+		wallet.derive()
+	}
+	return append(append([]WatchedAddress{}, wallet.Addresses...), wallet.derived...)
+}
+
+func (wallet *WatchOnlyWallet) addressSet() map[string]WatchedAddress {
+	addresses := make(map[string]WatchedAddress)
+	for _, watched := range wallet.watched() {
+		addresses[watched.Address] = watched
+	}
+	return addresses
+}
+
+func (wallet *WatchOnlyWallet) derive() {
+	wallet.derived = []WatchedAddress{}
+	for keyIndex, watchedKey := range wallet.ExtendedKeys {
+		for _, chain := range []uint32{ReceiveChain, ChangeChain} {
+			count := watchedKey.ReceiveCount
+			if chain == ChangeChain {
+				count = watchedKey.ChangeCount
+			}
+			addresses, err := DeriveAddresses(watchedKey.ExtendedKey, fmt.Sprintf("%d", chain), 0, count+DefaultGapLimit)
+			if err != nil {
+				fmt.Printf("Addresses of %s could not be derived: %s\n", watchedKey.ExtendedKey, err.Error())
+				continue
+			}
+			for _, address := range addresses {
+				wallet.derived = append(wallet.derived, WatchedAddress{
+					Address: address.Address,
+					Label:   watchedKey.Label,
+					Path:    address.Path,
+
+					extendedKey: keyIndex,
+				})
+			}
+		}
+	}
+}
+
+// markUsed moves the gap limit window of the extended key an address was derived from past it.
+func (wallet *WatchOnlyWallet) markUsed(watched WatchedAddress) bool {
+	if watched.Path == "" {
+		return false
+	}
+	indexes, err := ParseDerivationPath(watched.Path)
+	if err != nil || len(indexes) != 2 {
+		return false
+	}
+	watchedKey := &wallet.ExtendedKeys[watched.extendedKey]
+	count := &watchedKey.ReceiveCount
+	if indexes[0] == ChangeChain {
+		count = &watchedKey.ChangeCount
+	}
+	if indexes[1] < *count {
+		return false
+	}
+	*count = indexes[1] + 1
+	wallet.derived = nil
+	return true
+}
+
+// getKeystoreAddresses returns the keys in the keystore with their public key hash addresses.
+func getKeystoreAddresses() []WatchedAddress {
+	addresses := []WatchedAddress{}
+	for _, address := range WalletKeystore.GetStatus().Addresses {
+		addresses = append(addresses, WatchedAddress{Address: address})
+		publicKeyHash, err := GetPublicKeyHash(address)
+		if err == nil {
+			addresses = append(addresses, WatchedAddress{Address: publicKeyHash})
+		}
+	}
+	return addresses
+}
@@ -3,6 +3,7 @@ package crypto
 import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/x509"
 	"encoding/hex"
 	"encoding/pem"
@@ -57,6 +58,33 @@ func GetPublicECDSAKeyFromCompressedAddress (compressedAddress string) (*ecdsa.P
 	return key, nil
 }
 
+// GetCompressedAddress returns the address of a public key, the inverse of
+// GetPublicECDSAKeyFromCompressedAddress.
+func GetCompressedAddress (publicKey *ecdsa.PublicKey) string {
+	return hex.EncodeToString(elliptic.MarshalCompressed(elliptic.P256(), publicKey.X, publicKey.Y))
+}
+
+// GetPrivateKeyFromBytes builds a P-256 private key from its 32 byte big-endian scalar.
+func GetPrivateKeyFromBytes (privateBytes []byte) (*ecdsa.PrivateKey, error) {
+	curve := elliptic.P256()
+	if len(privateBytes) != 32 {
+		return nil, errors.New("invalid private key length")
+	}
+	d := new(big.Int).SetBytes(privateBytes)
+	if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("invalid private key")
+	}
+	privateKey := &ecdsa.PrivateKey{D: d}
+	privateKey.PublicKey.Curve = curve
+	privateKey.PublicKey.X, privateKey.PublicKey.Y = curve.ScalarBaseMult(privateBytes)
+	return privateKey, nil
+}
+
+// GetPrivateKeyBytes returns the 32 byte big-endian scalar of a P-256 private key.
+func GetPrivateKeyBytes (privateKey *ecdsa.PrivateKey) []byte {
+	return privateKey.D.FillBytes(make([]byte, 32))
+}
+
 func DeriveYFromCompressed(compressedAddress string) (*big.Int, *big.Int, error) {
 
 	compressedBytes, err := hex.DecodeString(compressedAddress)
@@ -125,3 +153,16 @@ func VerifyECDSASignature(publicKey *ecdsa.PublicKey, hashed string, signature s
 	}
 	return ecdsa.VerifyASN1(publicKey, hashBytes, signatureBytes), nil
 }
+
+// SignECDSA signs a hex encoded hash and returns the ASN.1 signature in hex, as VerifyECDSASignature expects it.
+func SignECDSA(privateKey *ecdsa.PrivateKey, hashed string) (string, error) {
+	hashBytes, err := hex.DecodeString(hashed)
+	if err != nil {
+		return "", err
+	}
+	signature, err := ecdsa.SignASN1(rand.Reader, privateKey, hashBytes)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(signature), nil
+}
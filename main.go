@@ -2,12 +2,46 @@ package main
 
 import (
 	"chacoin/crypto"
+	"flag"
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
 )
 
 func main() {
+	maxPoolSize := flag.Int64("mempool-size", crypto.DefaultMaxPoolSize, "maximum size of the transaction pool in bytes")
+	poolExpiry := flag.Int64("mempool-expiry", crypto.DefaultPoolExpiry, "seconds after which pending transactions are dropped")
+	minRelayFeeRate := flag.Int64("min-relay-fee", crypto.DefaultMinRelayFeeRate, "minimum fee rate per 1000 bytes for pool admission")
+	flag.StringVar(&crypto.PayoutAddress, "payout", "", "address receiving the coinbase of blocks assembled from the pool")
+	flag.StringVar(&crypto.FeeEstimatesFile, "fee-estimates", crypto.FeeEstimatesFile, "file the fee estimation statistics are persisted to")
+	flag.StringVar(&crypto.KeystoreFile, "keystore", crypto.KeystoreFile, "file the encrypted wallet keys are stored in")
+	flag.StringVar(&crypto.WalletTokenFile, "wallet-token", crypto.WalletTokenFile, "file holding the bearer token required by the wallet API")
+	flag.StringVar(&crypto.WalletsFile, "wallets", crypto.WalletsFile, "file the watch-only wallets are stored in")
+	flag.Int64Var(&crypto.OutputIndexHeight, "output-index-height", crypto.OutputIndexHeight, "block height from which outputs are indexed by position; chains started before must set their upgrade height")
+	network := flag.String("network", crypto.MainNet.Name, "network whose address prefix is used: mainnet or testnet")
+	flag.Parse()
+	err := crypto.SetNetwork(*network)
+	if err != nil {
+		log.Fatal(err)
+	}
+	crypto.TransactionPool = crypto.NewTxPool(*maxPoolSize, *poolExpiry, *minRelayFeeRate)
+	err = crypto.LoadFeeEstimates(crypto.FeeEstimatesFile)
+	if err != nil {
+		log.Printf("Fee estimates could not be loaded: %s", err.Error())
+	}
+	err = crypto.LoadKeystore(crypto.KeystoreFile)
+	if err != nil {
+		log.Fatalf("Keystore could not be loaded: %s", err.Error())
+	}
+	err = crypto.LoadWalletToken(crypto.WalletTokenFile)
+	if err != nil {
+		log.Fatalf("Wallet token could not be loaded: %s", err.Error())
+	}
+	err = crypto.LoadWatchOnlyWallets(crypto.WalletsFile)
+	if err != nil {
+		log.Fatalf("Watch-only wallets could not be loaded: %s", err.Error())
+	}
+
 	router := mux.NewRouter()
 	router.HandleFunc("/api/blocks", crypto.Blocks).Methods("GET")
 	router.HandleFunc("/api/status", crypto.Status).Methods("GET")
@@ -15,10 +49,53 @@ func main() {
 	router.HandleFunc("/api/unspent", crypto.Unspent).Methods("GET")
 	router.HandleFunc("/api/block/{hash}", crypto.GetBlock).Methods("GET")
 	router.HandleFunc("/api/address/{hash}", crypto.Address).Methods("GET")
+	router.HandleFunc("/api/address/{hash}/history", crypto.AddressHistory).Methods("GET")
+	router.HandleFunc("/api/validateAddress/{address}", crypto.GetAddressValidation).Methods("GET")
+	router.HandleFunc("/api/data/{payload}", crypto.DataCarriers).Methods("GET")
 	router.HandleFunc("/api/transaction/{id}", crypto.GetTransaction).Methods("GET")
 	router.HandleFunc("/api/transactionPool", crypto.GetTransactionPool).Methods("GET")
+	router.HandleFunc("/api/mempoolInfo", crypto.GetMempoolInfo).Methods("GET")
+	router.HandleFunc("/api/orphanPool", crypto.GetOrphanPool).Methods("GET")
+	router.HandleFunc("/api/estimatefee", crypto.EstimateFee).Methods("GET")
 	router.HandleFunc("/api/sendTransaction", crypto.SendTransaction).Methods("POST")
+	router.HandleFunc("/api/sendPackage", crypto.SendPackage).Methods("POST")
+	router.HandleFunc("/api/testAccept", crypto.TestAccept).Methods("POST")
 	router.HandleFunc("/api/mine", crypto.MineBlock).Methods("POST")
+	router.HandleFunc("/api/blockTemplate", crypto.GetBlockTemplate).Methods("GET")
+	router.HandleFunc("/api/htlc/create", crypto.CreateHTLC).Methods("POST")
+	router.HandleFunc("/api/htlc/redeem", crypto.RedeemHTLC).Methods("POST")
+	router.HandleFunc("/api/htlc/refund", crypto.RefundHTLC).Methods("POST")
+	router.HandleFunc("/api/htlc/preimage/{id}", crypto.HTLCPreimage).Methods("GET")
+	router.HandleFunc("/api/script/debug", crypto.DebugScript).Methods("POST")
+	wallet := router.PathPrefix("/api/wallet").Subrouter()
+	wallet.Use(crypto.RequireWalletAuth)
+	wallet.HandleFunc("/create", crypto.CreateWallet).Methods("POST")
+	wallet.HandleFunc("/unlock", crypto.UnlockWallet).Methods("POST")
+	wallet.HandleFunc("/lock", crypto.LockWallet).Methods("POST")
+	wallet.HandleFunc("/keys", crypto.GetWalletKeys).Methods("GET")
+	wallet.HandleFunc("/newAddress", crypto.NewWalletAddress).Methods("POST")
+	wallet.HandleFunc("/importKey", crypto.ImportWalletKey).Methods("POST")
+	wallet.HandleFunc("/send", crypto.SendFromWallet).Methods("POST")
+	wallet.HandleFunc("/history", crypto.GetWalletHistory).Methods("GET")
+	wallet.HandleFunc("/rescan", crypto.RescanWallet).Methods("POST")
+	wallet.HandleFunc("/mnemonic", crypto.NewWalletMnemonic).Methods("POST")
+	wallet.HandleFunc("/restore", crypto.RestoreWallet).Methods("POST")
+	wallet.HandleFunc("/signMessage", crypto.SignWalletMessage).Methods("POST")
+	router.HandleFunc("/api/verifyMessage", crypto.VerifySignedMessage).Methods("POST")
+	watch := router.PathPrefix("/api/watch").Subrouter()
+	watch.Use(crypto.RequireWalletAuth)
+	watch.HandleFunc("/wallets", crypto.GetWatchOnlyWallets).Methods("GET")
+	watch.HandleFunc("/wallets", crypto.CreateWatchOnlyWallet).Methods("POST")
+	watch.HandleFunc("/wallets/{name}", crypto.GetWatchOnlyWallet).Methods("GET")
+	watch.HandleFunc("/wallets/{name}/import", crypto.ImportWatched).Methods("POST")
+	watch.HandleFunc("/wallets/{name}/rescan", crypto.RescanWatchOnlyWallet).Methods("POST")
+	router.HandleFunc("/api/psbt/create", crypto.CreatePartiallySigned).Methods("POST")
+	router.HandleFunc("/api/psbt/decode", crypto.DecodePartiallySigned).Methods("POST")
+	router.Handle("/api/psbt/sign", crypto.RequireWalletAuth(http.HandlerFunc(crypto.SignPartiallySigned))).Methods("POST")
+	router.HandleFunc("/api/psbt/combine", crypto.CombinePartiallySigned).Methods("POST")
+	router.HandleFunc("/api/psbt/finalize", crypto.FinalizePartiallySigned).Methods("POST")
+	router.HandleFunc("/api/psbt/broadcast", crypto.BroadcastPartiallySigned).Methods("POST")
+	router.HandleFunc("/api/hd/addresses", crypto.DeriveHDAddresses).Methods("POST")
 	router.HandleFunc("/ws", crypto.HandleWSConnections)
 	go crypto.HandleMessages()
 	log.Fatal(http.ListenAndServe(":3000", router))